@@ -56,6 +56,7 @@ type format_1_18Serialization struct {
 	StatePort       int    `yaml:",omitempty"`
 	SharedSecret    string `yaml:",omitempty"`
 	SystemIdentity  string `yaml:",omitempty"`
+	APIBindAddress  string `yaml:",omitempty"`
 }
 
 func init() {
@@ -129,6 +130,7 @@ func (formatter_1_18) unmarshal(data []byte) (*configInternal, error) {
 			StatePort:      format.StatePort,
 			SharedSecret:   format.SharedSecret,
 			SystemIdentity: format.SystemIdentity,
+			APIBindAddress: format.APIBindAddress,
 		}
 		// There's a private key, then we need the state port,
 		// which wasn't always in the  1.18 format. If it's not present
@@ -179,6 +181,7 @@ func (formatter_1_18) marshal(config *configInternal) ([]byte, error) {
 		format.StatePort = config.servingInfo.StatePort
 		format.SharedSecret = config.servingInfo.SharedSecret
 		format.SystemIdentity = config.servingInfo.SystemIdentity
+		format.APIBindAddress = config.servingInfo.APIBindAddress
 	}
 	if config.stateDetails != nil {
 		format.StateAddresses = config.stateDetails.addresses
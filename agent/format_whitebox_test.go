@@ -93,11 +93,12 @@ func (*formatSuite) TestRead(c *gc.C) {
 
 func (*formatSuite) TestReadWriteStateConfig(c *gc.C) {
 	servingInfo := params.StateServingInfo{
-		Cert:         "some special cert",
-		PrivateKey:   "a special key",
-		CAPrivateKey: "ca special key",
-		StatePort:    12345,
-		APIPort:      23456,
+		Cert:           "some special cert",
+		PrivateKey:     "a special key",
+		CAPrivateKey:   "ca special key",
+		StatePort:      12345,
+		APIPort:        23456,
+		APIBindAddress: "10.0.0.1",
 	}
 	params := agentParams
 	params.DataDir = c.MkDir()
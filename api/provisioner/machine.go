@@ -302,6 +302,28 @@ func (m *Machine) WatchAllContainers() (watcher.StringsWatcher, error) {
 	return w, nil
 }
 
+// WatchUnits returns a StringsWatcher that notifies of changes to the
+// units assigned to the machine.
+func (m *Machine) WatchUnits() (watcher.StringsWatcher, error) {
+	var results params.StringsWatchResults
+	args := params.Entities{
+		Entities: []params.Entity{{Tag: m.tag.String()}},
+	}
+	err := m.st.facade.FacadeCall("WatchUnits", args, &results)
+	if err != nil {
+		return nil, err
+	}
+	if len(results.Results) != 1 {
+		return nil, fmt.Errorf("expected 1 result, got %d", len(results.Results))
+	}
+	result := results.Results[0]
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	w := watcher.NewStringsWatcher(m.st.facade.RawAPICaller(), result)
+	return w, nil
+}
+
 // SetSupportedContainers updates the list of containers supported by this machine.
 func (m *Machine) SetSupportedContainers(containerTypes ...instance.ContainerType) error {
 	var results params.ErrorResults
@@ -566,6 +566,32 @@ func (s *provisionerSuite) TestWatchContainers(c *gc.C) {
 	wc.AssertClosed()
 }
 
+func (s *provisionerSuite) TestWatchUnits(c *gc.C) {
+	machine, err := s.State.AddMachine("quantal", state.JobHostUnits)
+	c.Assert(err, jc.ErrorIsNil)
+	apiMachine, err := s.provisioner.Machine(machine.Tag().(names.MachineTag))
+	c.Assert(err, jc.ErrorIsNil)
+
+	w, err := apiMachine.WatchUnits()
+	c.Assert(err, jc.ErrorIsNil)
+	defer statetesting.AssertStop(c, w)
+	wc := statetesting.NewStringsWatcherC(c, s.BackingState, w)
+
+	// Initial event.
+	wc.AssertChange()
+
+	// Assign a unit to the machine and make sure it's detected.
+	wordpress := s.AddTestingService(c, "wordpress", s.AddTestingCharm(c, "wordpress"))
+	unit, err := wordpress.AddUnit()
+	c.Assert(err, jc.ErrorIsNil)
+	err = unit.AssignToMachine(machine)
+	c.Assert(err, jc.ErrorIsNil)
+	wc.AssertChange(unit.Name())
+
+	statetesting.AssertStop(c, w)
+	wc.AssertClosed()
+}
+
 func (s *provisionerSuite) TestWatchContainersAcceptsSupportedContainers(c *gc.C) {
 	apiMachine, err := s.provisioner.Machine(s.machine.Tag().(names.MachineTag))
 	c.Assert(err, jc.ErrorIsNil)
@@ -454,6 +454,11 @@ type StateServingInfo struct {
 	// this will be passed as the KeyFile argument to MongoDB
 	SharedSecret   string
 	SystemIdentity string
+	// APIBindAddress, if non-empty, holds the IP address on which
+	// the API server listens. It is specific to a single state
+	// server so it is not stored in state; if it is empty, the API
+	// server listens on all interfaces.
+	APIBindAddress string `json:",omitempty"`
 }
 
 // IsMasterResult holds the result of an IsMaster API call.
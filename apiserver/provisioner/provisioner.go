@@ -57,6 +57,7 @@ type ProvisionerAPI struct {
 	*common.APIAddresser
 	*common.EnvironWatcher
 	*common.EnvironMachinesWatcher
+	*common.UnitsWatcher
 	*common.InstanceIdGetter
 	*common.ToolsFinder
 	*common.ToolsGetter
@@ -120,6 +121,7 @@ func NewProvisionerAPI(st *state.State, resources *common.Resources, authorizer
 		APIAddresser:           common.NewAPIAddresser(st, resources),
 		EnvironWatcher:         common.NewEnvironWatcher(st, resources, authorizer),
 		EnvironMachinesWatcher: common.NewEnvironMachinesWatcher(st, resources, authorizer),
+		UnitsWatcher:           common.NewUnitsWatcher(st, resources, getAuthFunc),
 		InstanceIdGetter:       common.NewInstanceIdGetter(st, getAuthFunc),
 		ToolsFinder:            common.NewToolsFinder(st, st, urlGetter),
 		ToolsGetter:            common.NewToolsGetter(st, st, st, urlGetter, getAuthOwner),
@@ -1323,21 +1325,20 @@ func (p *ProvisionerAPI) createOrFetchStateSubnet(subnetInfo network.SubnetInfo)
 
 // machineTags returns machine-specific tags to set on the instance.
 func (p *ProvisionerAPI) machineTags(m *state.Machine, jobs []multiwatcher.MachineJob) (map[string]string, error) {
-	// Names of all units deployed to the machine.
-	//
-	// TODO(axw) 2015-06-02 #1461358
-	// We need a worker that periodically updates
-	// instance tags with current deployment info.
+	// Names of all units deployed to the machine. The provisioner
+	// keeps these up to date as units come and go, using WatchUnits.
 	units, err := m.Units()
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	unitNames := make([]string, 0, len(units))
+	serviceNames := set.NewStrings()
 	for _, unit := range units {
 		if !unit.IsPrincipal() {
 			continue
 		}
 		unitNames = append(unitNames, unit.Name())
+		serviceNames.Add(unit.ServiceName())
 	}
 	sort.Strings(unitNames)
 
@@ -1348,6 +1349,7 @@ func (p *ProvisionerAPI) machineTags(m *state.Machine, jobs []multiwatcher.Machi
 	machineTags := instancecfg.InstanceTags(cfg, jobs)
 	if len(unitNames) > 0 {
 		machineTags[tags.JujuUnitsDeployed] = strings.Join(unitNames, " ")
+		machineTags[tags.JujuServicesDeployed] = strings.Join(serviceNames.SortedValues(), " ")
 	}
 	return machineTags, nil
 }
@@ -538,6 +538,40 @@ func (s *withoutStateServerSuite) TestWatchAllContainers(c *gc.C) {
 	wc1.AssertNoChange()
 }
 
+func (s *withoutStateServerSuite) TestWatchUnits(c *gc.C) {
+	c.Assert(s.resources.Count(), gc.Equals, 0)
+	svc := s.AddTestingService(c, "wordpress", s.AddTestingCharm(c, "wordpress"))
+	unit, err := svc.AddUnit()
+	c.Assert(err, jc.ErrorIsNil)
+	err = unit.AssignToMachine(s.machines[0])
+	c.Assert(err, jc.ErrorIsNil)
+
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: s.machines[0].Tag().String()},
+		{Tag: "machine-42"},
+		{Tag: "unit-foo-0"},
+	}}
+	result, err := s.provisioner.WatchUnits(args)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(result, gc.DeepEquals, params.StringsWatchResults{
+		Results: []params.StringsWatchResult{
+			{StringsWatcherId: "1", Changes: []string{"wordpress/0"}},
+			{Error: apiservertesting.NotFoundError("machine 42")},
+			{Error: apiservertesting.ErrUnauthorized},
+		},
+	})
+
+	// Verify the resource was registered and stop it when done.
+	c.Assert(s.resources.Count(), gc.Equals, 1)
+	resource := s.resources.Get("1")
+	defer statetesting.AssertStop(c, resource)
+
+	// Check that the Watch has consumed the initial event ("returned"
+	// in the Watch call)
+	wc := statetesting.NewStringsWatcherC(c, s.State, resource.(state.StringsWatcher))
+	wc.AssertNoChange()
+}
+
 func (s *withoutStateServerSuite) TestEnvironConfigNonManager(c *gc.C) {
 	// Now test it with a non-environment manager and make sure
 	// the secret attributes are masked.
@@ -859,6 +893,29 @@ func (s *withoutStateServerSuite) TestProvisioningInfo(c *gc.C) {
 	c.Assert(result, jc.DeepEquals, expected)
 }
 
+func (s *withoutStateServerSuite) TestProvisioningInfoDeployedTags(c *gc.C) {
+	for _, name := range []string{"wordpress", "mysql"} {
+		svc := s.AddTestingService(c, name, s.AddTestingCharm(c, name))
+		unit, err := svc.AddUnit()
+		c.Assert(err, jc.ErrorIsNil)
+		err = unit.AssignToMachine(s.machines[0])
+		c.Assert(err, jc.ErrorIsNil)
+	}
+
+	args := params.Entities{Entities: []params.Entity{
+		{Tag: s.machines[0].Tag().String()},
+	}}
+	result, err := s.provisioner.ProvisioningInfo(args)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(result.Results, gc.HasLen, 1)
+	c.Assert(result.Results[0].Error, gc.IsNil)
+	c.Assert(result.Results[0].Result.Tags, jc.DeepEquals, map[string]string{
+		tags.JujuEnv:              coretesting.EnvironmentTag.Id(),
+		tags.JujuUnitsDeployed:    "mysql/0 wordpress/0",
+		tags.JujuServicesDeployed: "mysql wordpress",
+	})
+}
+
 func (s *withoutStateServerSuite) TestStorageProviderFallbackToType(c *gc.C) {
 	registry.RegisterProvider("dynamic", &storagedummy.StorageProvider{IsDynamic: true})
 	defer registry.RegisterProvider("dynamic", nil)
@@ -129,6 +129,37 @@ func NewServer(caCertPEM, caKeyPEM string, expiry time.Time, hostnames []string)
 	return newLeaf(caCertPEM, caKeyPEM, expiry, hostnames, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
 }
 
+// ReissueServer generates a new certificate/key pair for a server,
+// signed by the given CA, with an expiry time of 10 years. The new
+// certificate is valid for the host names and addresses of the given
+// existing server certificate as well as for extraHostnames. It can be
+// used to rotate a server's certificate, for example after the CA is
+// replaced or when the server gains a new name.
+func ReissueServer(caCertPEM, caKeyPEM, serverCertPEM string, extraHostnames []string) (certPEM, keyPEM string, err error) {
+	serverCert, err := ParseCert(serverCertPEM)
+	if err != nil {
+		return "", "", errors.Annotate(err, "cannot parse server certificate")
+	}
+	seen := make(map[string]bool)
+	var hostnames []string
+	add := func(hostname string) {
+		if !seen[hostname] {
+			seen[hostname] = true
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	for _, name := range serverCert.DNSNames {
+		add(name)
+	}
+	for _, ip := range serverCert.IPAddresses {
+		add(ip.String())
+	}
+	for _, hostname := range extraHostnames {
+		add(hostname)
+	}
+	return NewDefaultServer(caCertPEM, caKeyPEM, hostnames)
+}
+
 // NewClient generates a certificate/key pair suitable for client authentication.
 func NewClient(caCertPEM, caKeyPEM string, expiry time.Time) (certPEM, keyPEM string, err error) {
 	return newLeaf(caCertPEM, caKeyPEM, expiry, nil, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
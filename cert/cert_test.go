@@ -160,6 +160,30 @@ func (certSuite) TestNewServerHostnames(c *gc.C) {
 	}
 }
 
+func (certSuite) TestReissueServer(c *gc.C) {
+	now := time.Now()
+	caCert, _, err := cert.ParseCertAndKey(caCertPEM, caKeyPEM)
+	c.Assert(err, jc.ErrorIsNil)
+	oldCertPEM, _, err := cert.NewDefaultServer(caCertPEM, caKeyPEM, []string{"juju-apiserver", "10.0.0.1"})
+	c.Assert(err, jc.ErrorIsNil)
+
+	srvCertPEM, srvKeyPEM, err := cert.ReissueServer(caCertPEM, caKeyPEM, oldCertPEM, []string{"controller.example.com", "10.0.0.1", "10.0.0.2"})
+	c.Assert(err, jc.ErrorIsNil)
+	checkCertificate(c, caCert, srvCertPEM, srvKeyPEM, now, roundTime(now.AddDate(10, 0, 0)))
+	srvCert, err := cert.ParseCert(srvCertPEM)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(srvCert.DNSNames, jc.DeepEquals, []string{"juju-apiserver", "controller.example.com"})
+	c.Assert(srvCert.IPAddresses, jc.DeepEquals, []net.IP{
+		net.IPv4(10, 0, 0, 1).To4(),
+		net.IPv4(10, 0, 0, 2).To4(),
+	})
+}
+
+func (certSuite) TestReissueServerInvalidServerCert(c *gc.C) {
+	_, _, err := cert.ReissueServer(caCertPEM, caKeyPEM, "not a cert", nil)
+	c.Assert(err, gc.ErrorMatches, "cannot parse server certificate: .*")
+}
+
 func (certSuite) TestWithNonUTCExpiry(c *gc.C) {
 	expiry, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2012-11-28 15:53:57 +0100 CET")
 	c.Assert(err, jc.ErrorIsNil)
@@ -7,6 +7,7 @@ package instancecfg
 import (
 	"fmt"
 	"net"
+	"os"
 	"path"
 	"strconv"
 
@@ -170,6 +171,11 @@ type InstanceConfig struct {
 	// in non-bootstrap instances.
 	CustomImageMetadata []*imagemetadata.ImageMetadata
 
+	// BootstrapFiles holds additional files, such as CA certificate
+	// bundles, to write to the instance before the state server is
+	// initialised. This is ignored in non-bootstrap instances.
+	BootstrapFiles []BootstrapFile
+
 	// EnableOSRefreshUpdate specifies whether Juju will refresh its
 	// respective OS's updates list.
 	EnableOSRefreshUpdate bool
@@ -180,6 +186,38 @@ type InstanceConfig struct {
 	EnableOSUpgrade bool
 }
 
+// MaxBootstrapFileSize is the largest file that may be written to a
+// bootstrap instance with BootstrapFiles. The files are carried in the
+// instance's user data, which clouds limit in size.
+const MaxBootstrapFileSize = 16 * 1024
+
+// BootstrapFile holds a file to write to a bootstrap instance.
+type BootstrapFile struct {
+	// Path is the absolute path of the file.
+	Path string
+
+	// Content holds the contents of the file.
+	Content string
+
+	// Permissions holds the permission bits of the file.
+	Permissions os.FileMode
+}
+
+// Validate returns an error if the file cannot be written to a
+// bootstrap instance.
+func (f BootstrapFile) Validate() error {
+	if !path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path || f.Path == "/" {
+		return errors.Errorf("bootstrap file path %q is not a clean absolute path", f.Path)
+	}
+	if len(f.Content) > MaxBootstrapFileSize {
+		return errors.Errorf("bootstrap file %q is larger than %d bytes", f.Path, MaxBootstrapFileSize)
+	}
+	if f.Permissions&^os.ModePerm != 0 {
+		return errors.Errorf("bootstrap file %q has invalid permissions %v", f.Path, f.Permissions)
+	}
+	return nil
+}
+
 func (cfg *InstanceConfig) agentInfo() service.AgentInfo {
 	return service.NewMachineAgentInfo(
 		cfg.MachineId,
@@ -260,7 +298,11 @@ func (cfg *InstanceConfig) stateHostAddrs() []string {
 func (cfg *InstanceConfig) ApiHostAddrs() []string {
 	var hosts []string
 	if cfg.Bootstrap {
-		if cfg.PreferIPv6 {
+		// If the API server is bound to a specific address then
+		// it cannot be reached via the loopback interface.
+		if bindAddress := cfg.StateServingInfo.APIBindAddress; bindAddress != "" {
+			hosts = append(hosts, net.JoinHostPort(bindAddress, strconv.Itoa(cfg.StateServingInfo.APIPort)))
+		} else if cfg.PreferIPv6 {
 			hosts = append(hosts, net.JoinHostPort("::1", strconv.Itoa(cfg.StateServingInfo.APIPort)))
 		} else {
 			hosts = append(hosts, net.JoinHostPort("localhost", strconv.Itoa(cfg.StateServingInfo.APIPort)))
@@ -352,9 +394,17 @@ func (cfg *InstanceConfig) VerifyConfig() (err error) {
 		if cfg.StateServingInfo.APIPort == 0 {
 			return errors.New("missing API port")
 		}
+		if addr := cfg.StateServingInfo.APIBindAddress; addr != "" && net.ParseIP(addr) == nil {
+			return errors.Errorf("invalid API bind address %q", addr)
+		}
 		if cfg.InstanceId == "" {
 			return errors.New("missing instance-id")
 		}
+		for _, f := range cfg.BootstrapFiles {
+			if err := f.Validate(); err != nil {
+				return errors.Trace(err)
+			}
+		}
 	} else {
 		if len(cfg.MongoInfo.Addrs) == 0 {
 			return errors.New("missing state hosts")
@@ -504,6 +504,48 @@ curl .* --noproxy "\*" --insecure -o \$bin/tools\.tar\.gz 'https://state-addr\.t
 		inexactMatch: true,
 		expectScripts: `
 /var/lib/juju/tools/1\.2\.3-precise-amd64/jujud bootstrap-state --data-dir '/var/lib/juju' --env-config '[^']*' --instance-id 'i-bootstrap' --debug
+`,
+	}, {
+		// bootstrap files.
+		cfg: instancecfg.InstanceConfig{
+			MachineId:        "0",
+			AuthorizedKeys:   "sshkey1",
+			AgentEnvironment: map[string]string{agent.ProviderType: "dummy"},
+			// precise currently needs mongo from PPA
+			Tools:            newSimpleTools("1.2.3-precise-amd64"),
+			Series:           "precise",
+			Bootstrap:        true,
+			StateServingInfo: stateServingInfo,
+			MachineNonce:     "FAKE_NONCE",
+			MongoInfo: &mongo.MongoInfo{
+				Password: "arble",
+				Info: mongo.Info{
+					CACert: "CA CERT\n" + testing.CACert,
+				},
+			},
+			APIInfo: &api.Info{
+				Password:   "bletch",
+				CACert:     "CA CERT\n" + testing.CACert,
+				EnvironTag: testing.EnvironmentTag,
+			},
+			DataDir:                 dataDir,
+			LogDir:                  jujuLogDir,
+			Jobs:                    allMachineJobs,
+			CloudInitOutputLog:      cloudInitOutputLog,
+			InstanceId:              "i-bootstrap",
+			MachineAgentServiceName: "jujud-machine-0",
+			EnableOSRefreshUpdate:   true,
+			BootstrapFiles: []instancecfg.BootstrapFile{{
+				Path:        "/usr/local/share/ca-certificates/cloud.crt",
+				Content:     "trust bundle",
+				Permissions: 0644,
+			}},
+		},
+		setEnvConfig: true,
+		inexactMatch: true,
+		expectScripts: `
+install -D -m 644 /dev/null '/usr/local/share/ca-certificates/cloud\.crt'
+printf '%s\\n' 'trust bundle' > '/usr/local/share/ca-certificates/cloud\.crt'
 `,
 	}, {
 		// custom image metadata.
@@ -292,6 +292,10 @@ func (w *unixConfigure) ConfigureJuju() error {
 	}
 
 	if w.icfg.Bootstrap {
+		for _, f := range w.icfg.BootstrapFiles {
+			w.conf.AddRunTextFile(f.Path, f.Content, uint(f.Permissions))
+		}
+
 		var metadataDir string
 		if len(w.icfg.CustomImageMetadata) > 0 {
 			metadataDir = path.Join(w.icfg.DataDir, "simplestreams")
@@ -310,6 +310,7 @@ func (c *BootstrapCommand) Run(ctx *cmd.Context) (resultErr error) {
 		UploadTools:  c.UploadTools,
 		AgentVersion: c.AgentVersion,
 		MetadataDir:  metadataDir,
+		KeepBroken:   c.KeepBrokenEnvironment,
 	})
 	if err != nil {
 		return errors.Annotate(err, "failed to bootstrap environment")
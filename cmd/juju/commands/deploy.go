@@ -22,6 +22,7 @@ import (
 	"github.com/juju/juju/constraints"
 	"github.com/juju/juju/juju/osenv"
 	"github.com/juju/juju/storage"
+	"github.com/juju/juju/version"
 )
 
 type DeployCommand struct {
@@ -36,6 +37,10 @@ type DeployCommand struct {
 	RepoPath     string // defaults to JUJU_REPOSITORY
 	RegisterURL  string
 
+	// Series, if non-empty, holds the series to deploy the charm
+	// on when the charm name does not specify one.
+	Series string
+
 	// TODO(axw) move this to UnitCommandBase once we support --storage
 	// on add-unit too.
 	//
@@ -65,6 +70,10 @@ However, for local charms, when the default-series is not specified in the
 environment, one must specify the series. For example:
   local:precise/mysql
 
+The series can also be given with the --series flag, which overrides the
+default-series setting. It must not conflict with a series in the charm
+name.
+
 <service name>, if omitted, will be derived from <charm name>.
 
 Constraints can be specified when using deploy by specifying the --constraints
@@ -72,6 +81,12 @@ flag.  When used with deploy, service-specific constraints are set so that later
 machines provisioned with add-unit will use the same constraints (unless changed
 by set-constraints).
 
+Storage for charms that declare it can be specified with the --storage
+flag, which may be given once for each store named in the charm's
+metadata. Its value has the form <store>=<pool>,<size>,<count>, where
+each of the pool, size and count is optional. The pool must already
+exist in the environment.
+
 Charms can be deployed to a specific machine using the --to argument.
 If the destination is an LXC container the default is to use lxc-clone
 to create the container where possible. For Ubuntu deployments, lxc-clone
@@ -99,6 +114,9 @@ Examples:
    juju deploy mysql -n 5 --constraints mem=8G
    (deploy 5 instances of mysql with at least 8 GB of RAM each)
 
+   juju deploy postgresql --storage pgdata=ebs,10G
+   (deploy postgresql with a 10 GB volume from the ebs pool for its pgdata store)
+
 See Also:
    juju help constraints
    juju help set-constraints
@@ -124,6 +142,7 @@ func (c *DeployCommand) SetFlags(f *gnuflag.FlagSet) {
 	f.StringVar(&c.Networks, "networks", "", "deprecated and ignored: use space constraints instead.")
 	f.StringVar(&c.RepoPath, "repository", os.Getenv(osenv.JujuRepositoryEnvKey), "local charm repository")
 	f.Var(storageFlag{&c.Storage}, "storage", "charm storage constraints")
+	f.StringVar(&c.Series, "series", "", "the series to deploy the charm on")
 }
 
 func (c *DeployCommand) Init(args []string) error {
@@ -139,6 +158,9 @@ func (c *DeployCommand) Init(args []string) error {
 			return fmt.Errorf("invalid charm name %q", args[0])
 		}
 		c.CharmName = args[0]
+		if err := c.applySeries(); err != nil {
+			return err
+		}
 	case 0:
 		return errors.New("no charm specified")
 	default:
@@ -147,6 +169,28 @@ func (c *DeployCommand) Init(args []string) error {
 	return c.UnitCommandBase.Init(args)
 }
 
+// applySeries checks that the series given with --series is known
+// and adds it to the charm name, which must not specify a different
+// series.
+func (c *DeployCommand) applySeries() error {
+	if c.Series == "" {
+		return nil
+	}
+	if _, err := version.GetOSFromSeries(c.Series); err != nil {
+		return fmt.Errorf("unknown series %q", c.Series)
+	}
+	ref, err := charm.ParseReference(c.CharmName)
+	if err != nil {
+		return fmt.Errorf("invalid charm name %q", c.CharmName)
+	}
+	if ref.Series != "" && ref.Series != c.Series {
+		return fmt.Errorf("charm %q is for series %q, not %q", c.CharmName, ref.Series, c.Series)
+	}
+	ref.Series = c.Series
+	c.CharmName = ref.String()
+	return nil
+}
+
 func (c *DeployCommand) newServiceAPIClient() (*apiservice.Client, error) {
 	root, err := c.NewAPIRoot()
 	if err != nil {
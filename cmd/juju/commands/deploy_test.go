@@ -85,6 +85,27 @@ var initErrorTests = []struct {
 	}, {
 		args: []string{"craziness", "burble1", "--constraints", "gibber=plop"},
 		err:  `invalid value "gibber=plop" for flag --constraints: unknown constraint "gibber"`,
+	}, {
+		args: []string{"craziness", "burble1", "--storage", "data"},
+		err:  `invalid value "data" for flag --storage: expected <store>=<constraints>`,
+	}, {
+		args: []string{"craziness", "burble1", "--storage", "=loop,1G"},
+		err:  `invalid value "=loop,1G" for flag --storage: expected <store>=<constraints>`,
+	}, {
+		args: []string{"craziness", "burble1", "--storage", "data=,"},
+		err:  `invalid value "data=," for flag --storage: cannot parse disk constraints: storage constraints require at least one field to be specified`,
+	}, {
+		args: []string{"craziness", "burble1", "--storage", "data=1G", "--storage", "data=2G"},
+		err:  `invalid value "data=2G" for flag --storage: storage "data" specified more than once`,
+	}, {
+		args: []string{"craziness", "burble1", "--to", "zone="},
+		err:  `invalid --to parameter "zone=": expected <key>=<value>`,
+	}, {
+		args: []string{"craziness", "burble1", "--series", "nonsense"},
+		err:  `unknown series "nonsense"`,
+	}, {
+		args: []string{"precise/craziness", "burble1", "--series", "trusty"},
+		err:  `charm "precise/craziness" is for series "precise", not "trusty"`,
 	},
 }
 
@@ -117,6 +138,14 @@ func (s *DeploySuite) TestCharmDir(c *gc.C) {
 	s.AssertService(c, "dummy", curl, 1, 0)
 }
 
+func (s *DeploySuite) TestCharmDirWithSeries(c *gc.C) {
+	testcharms.Repo.ClonedDirPath(s.SeriesPath, "dummy")
+	err := runDeploy(c, "local:dummy", "--series", "quantal")
+	c.Assert(err, jc.ErrorIsNil)
+	curl := charm.MustParseURL("local:quantal/dummy-1")
+	s.AssertService(c, "dummy", curl, 1, 0)
+}
+
 func (s *DeploySuite) TestUpgradeReportsDeprecated(c *gc.C) {
 	testcharms.Repo.ClonedDirPath(s.SeriesPath, "dummy")
 	ctx, err := coretesting.RunCommand(c, envcmd.Wrap(&DeployCommand{}), "local:dummy", "-u")
@@ -260,6 +289,15 @@ func (s *DeploySuite) TestPlacement(c *gc.C) {
 	c.Assert(mid, gc.Not(gc.Equals), machine.Id())
 }
 
+func (s *DeploySuite) TestInitZonePlacement(c *gc.C) {
+	deploy := &DeployCommand{}
+	err := coretesting.InitCommand(envcmd.Wrap(deploy), []string{"local:dummy", "--to", "zone=az1"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(deploy.Placement, jc.DeepEquals, []*instance.Placement{
+		{Scope: "env-uuid", Directive: "zone=az1"},
+	})
+}
+
 func (s *DeploySuite) TestSubordinateConstraints(c *gc.C) {
 	testcharms.Repo.CharmArchivePath(s.SeriesPath, "logging")
 	err := runDeploy(c, "local:logging", "--constraints", "mem=1G")
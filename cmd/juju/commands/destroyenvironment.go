@@ -157,10 +157,13 @@ func (c *DestroyEnvironmentCommand) Run(ctx *cmd.Context) (result error) {
 		if err := c.destroyEnv(apiclient); err != nil {
 			return errors.Annotate(err, "environment destruction failed")
 		}
+		// environs.Destroy removes the environment info, unless the
+		// provider only stopped the environment and it must be kept
+		// for destroying it again later.
 		if err := environs.Destroy(serverEnviron, store); err != nil {
 			return errors.Annotate(err, "environment destruction failed")
 		}
-		return environs.DestroyInfo(c.envName, store)
+		return nil
 	}
 
 	// If this is not the server environment, there is no bootstrap info and
@@ -19,7 +19,7 @@ type storageFlag struct {
 // Set implements gnuflag.Value.Set.
 func (f storageFlag) Set(s string) error {
 	fields := strings.SplitN(s, "=", 2)
-	if len(fields) < 2 {
+	if len(fields) < 2 || fields[0] == "" {
 		return errors.New("expected <store>=<constraints>")
 	}
 	cons, err := storage.ParseConstraints(fields[1])
@@ -29,6 +29,9 @@ func (f storageFlag) Set(s string) error {
 	if *f.stores == nil {
 		*f.stores = make(map[string]storage.Constraints)
 	}
+	if _, ok := (*f.stores)[fields[0]]; ok {
+		return errors.Errorf("storage %q specified more than once", fields[0])
+	}
 	(*f.stores)[fields[0]] = cons
 	return nil
 }
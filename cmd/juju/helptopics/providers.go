@@ -133,7 +133,9 @@ Placement directives:
       The "zone" placement directive instructs the OpenStack provider to
       allocate a machine in the specified availability zone. If the zone
       does not exist, or a machine cannot be allocated within it, then
-      the machine addition will fail.
+      the machine addition will fail. When bootstrapping, the zone is
+      checked before any resources are provisioned, and bootstrap fails
+      if the zone is unknown or unavailable.
 
 Other OpenStack Based Clouds:
 
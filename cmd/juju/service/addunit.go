@@ -70,9 +70,24 @@ func parsePlacement(spec string) (*instance.Placement, error) {
 	if err != nil {
 		return nil, errors.Errorf("invalid --to parameter %q", spec)
 	}
+	// Environment directives such as zone=<name> must have both a
+	// key and a value.
+	if key, value, ok := splitDirective(placement.Directive); ok && (key == "" || value == "") {
+		return nil, errors.Errorf("invalid --to parameter %q: expected <key>=<value>", placement.Directive)
+	}
 	return placement, nil
 }
 
+// splitDirective splits a key=value placement directive, reporting
+// whether the directive has that form.
+func splitDirective(directive string) (key, value string, ok bool) {
+	eq := strings.IndexRune(directive, '=')
+	if eq == -1 {
+		return "", "", false
+	}
+	return directive[:eq], directive[eq+1:], true
+}
+
 // TODO(anastasiamac) 2014-10-20 Bug#1383116
 // This exists to provide more context to the user about
 // why they cannot allocate units to machine 0. Remove
@@ -631,6 +631,17 @@ func (a *MachineAgent) stateStarter(stopch <-chan struct{}) error {
 	}
 }
 
+// setStateServingInfo records serving info read from state in the
+// agent config and returns the info recorded. The API bind address is
+// held only in the agent config, so it is kept from there.
+func setStateServingInfo(config agent.ConfigSetter, info params.StateServingInfo) params.StateServingInfo {
+	if current, ok := config.StateServingInfo(); ok && info.APIBindAddress == "" {
+		info.APIBindAddress = current.APIBindAddress
+	}
+	config.SetStateServingInfo(info)
+	return info
+}
+
 // APIWorker returns a Worker that connects to the API and starts any
 // workers that need an API connection.
 func (a *MachineAgent) APIWorker() (_ worker.Worker, err error) {
@@ -662,7 +673,7 @@ func (a *MachineAgent) APIWorker() (_ worker.Worker, err error) {
 				return nil, fmt.Errorf("cannot get state serving info: %v", err)
 			}
 			err = a.ChangeConfig(func(config agent.ConfigSetter) error {
-				config.SetStateServingInfo(info)
+				setStateServingInfo(config, info)
 				return nil
 			})
 			if err != nil {
@@ -1038,7 +1049,7 @@ func (a *MachineAgent) StateWorker() (worker.Worker, error) {
 			runner.StartWorker("apiserver", a.apiserverWorkerStarter(st, certChangedChan))
 			var stateServingSetter certupdater.StateServingInfoSetter = func(info params.StateServingInfo, done <-chan struct{}) error {
 				return a.ChangeConfig(func(config agent.ConfigSetter) error {
-					config.SetStateServingInfo(info)
+					info = setStateServingInfo(config, info)
 					logger.Infof("update apiserver worker with new certificate")
 					select {
 					case certChangedChan <- info:
@@ -1223,7 +1234,7 @@ func (a *MachineAgent) newApiserverWorker(st *state.State, certChanged chan para
 	dataDir := agentConfig.DataDir()
 	logDir := agentConfig.LogDir()
 
-	endpoint := net.JoinHostPort("", strconv.Itoa(info.APIPort))
+	endpoint := net.JoinHostPort(info.APIBindAddress, strconv.Itoa(info.APIPort))
 	listener, err := net.Listen("tcp", endpoint)
 	if err != nil {
 		return nil, err
@@ -1584,6 +1584,53 @@ func (s *MachineSuite) TestMachineAgentRunsCertificateUpdateWorkerForStateServer
 	}
 }
 
+func (s *MachineSuite) TestMachineAgentKeepsAPIBindAddress(c *gc.C) {
+	setters := make(chan certupdater.StateServingInfoSetter, 1)
+	newUpdater := func(_ certupdater.AddressWatcher, _ certupdater.StateServingInfoGetter, _ certupdater.EnvironConfigGetter,
+		_ certupdater.APIHostPortsGetter, setter certupdater.StateServingInfoSetter, _ chan params.StateServingInfo,
+	) worker.Worker {
+		select {
+		case setters <- setter:
+		default:
+		}
+		return worker.NewNoOpWorker()
+	}
+	s.PatchValue(&newCertificateUpdater, newUpdater)
+
+	// The bind address is held only in the agent config, not in state.
+	m, agentConfig, _ := s.primeAgent(c, version.Current, state.JobManageEnviron)
+	info, ok := agentConfig.StateServingInfo()
+	c.Assert(ok, jc.IsTrue)
+	info.APIBindAddress = "0.0.0.0"
+	agentConfig.SetStateServingInfo(info)
+	err := agentConfig.Write()
+	c.Assert(err, jc.ErrorIsNil)
+
+	a := s.newAgent(c, m)
+	go func() { c.Check(a.Run(nil), jc.ErrorIsNil) }()
+	defer func() { c.Check(a.Stop(), jc.ErrorIsNil) }()
+
+	// The certificate updater is started once the serving info has
+	// been refreshed from state.
+	var setter certupdater.StateServingInfoSetter
+	select {
+	case setter = <-setters:
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("timeout while waiting for certificate update worker to start")
+	}
+	info, ok = a.CurrentConfig().StateServingInfo()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(info.APIBindAddress, gc.Equals, "0.0.0.0")
+
+	// The certificate updater also sets serving info read from state.
+	info.APIBindAddress = ""
+	err = setter(info, make(chan struct{}))
+	c.Assert(err, jc.ErrorIsNil)
+	info, ok = a.CurrentConfig().StateServingInfo()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(info.APIBindAddress, gc.Equals, "0.0.0.0")
+}
+
 func (s *MachineSuite) TestMachineAgentDoesNotRunsCertificateUpdateWorkerForNonStateServer(c *gc.C) {
 	started := make(chan struct{})
 	newUpdater := func(certupdater.AddressWatcher, certupdater.StateServingInfoGetter, certupdater.EnvironConfigGetter,
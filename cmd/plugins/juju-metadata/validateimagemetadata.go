@@ -56,6 +56,11 @@ Examples:
 
   juju metadata validate-images -s raring -d <some directory>
 
+ - report which of several series have images, using the current
+ environment settings; the command fails if any series has none
+
+  juju metadata validate-images -s precise,trusty,vivid
+
 A key use case is to validate newly generated metadata prior to deployment to
 production. In this case, the metadata is placed in a local directory, a cloud
 provider type is specified (ec2, openstack etc), and the validation is performed
@@ -83,7 +88,7 @@ func (c *ValidateImageMetadataCommand) SetFlags(f *gnuflag.FlagSet) {
 	c.out.AddFlags(f, "smart", cmd.DefaultFormatters)
 	f.StringVar(&c.providerType, "p", "", "the provider type eg ec2, openstack")
 	f.StringVar(&c.metadataDir, "d", "", "directory where metadata files are found")
-	f.StringVar(&c.series, "s", "", "the series for which to validate (overrides env config series)")
+	f.StringVar(&c.series, "s", "", "the series for which to validate (overrides env config series); a comma-separated list reports the images available for each")
 	f.StringVar(&c.region, "r", "", "the region for which to validate (overrides env config region)")
 	f.StringVar(&c.endpoint, "u", "", "the cloud endpoint URL for which to validate (overrides env config endpoint)")
 	f.StringVar(&c.stream, "m", "", "the images stream (defaults to released)")
@@ -189,6 +194,10 @@ func (c *ValidateImageMetadataCommand) Run(context *cmd.Context) error {
 	}
 	params.Stream = c.stream
 
+	if series := strings.Split(c.series, ","); len(series) > 1 {
+		return c.checkImageAvailability(context, params, series)
+	}
+
 	image_ids, resolveInfo, err := imagemetadata.ValidateImageMetadata(params)
 	if err != nil {
 		if resolveInfo != nil {
@@ -222,3 +231,47 @@ func (c *ValidateImageMetadataCommand) Run(context *cmd.Context) error {
 	}
 	return nil
 }
+
+// checkImageAvailability writes the architectures for which image
+// metadata is available for each of the given series, and returns an
+// error if there is a series with none.
+func (c *ValidateImageMetadataCommand) checkImageAvailability(
+	context *cmd.Context, params *simplestreams.MetadataLookupParams, series []string,
+) error {
+	cloudSpec := simplestreams.CloudSpec{
+		Region:   params.Region,
+		Endpoint: params.Endpoint,
+	}
+	results, err := imagemetadata.CheckImageAvailability(
+		params.Sources, cloudSpec, params.Stream, series, params.Architectures,
+	)
+	if err != nil {
+		return err
+	}
+	available := make(map[string][]string)
+	for _, result := range results {
+		if _, ok := available[result.Series]; !ok {
+			available[result.Series] = []string{}
+		}
+		if result.Available {
+			available[result.Series] = append(available[result.Series], result.Arch)
+		}
+	}
+	var missing []string
+	for _, s := range series {
+		if len(available[s]) == 0 {
+			missing = append(missing, s)
+		}
+	}
+	metadata := map[string]interface{}{
+		"Region":    params.Region,
+		"Available": available,
+	}
+	if err := c.out.Write(context, metadata); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no images for series %s in region %s", strings.Join(missing, ", "), params.Region)
+	}
+	return nil
+}
@@ -235,3 +235,40 @@ func (s *ValidateImageMetadataSuite) TestOpenstackLocalMetadataNoMatch(c *gc.C)
 	strippedOut = strings.Replace(errOut, "\n", "", -1)
 	c.Check(strippedOut, gc.Matches, `.*Resolve Metadata:.*`)
 }
+
+func (s *ValidateImageMetadataSuite) TestOpenstackLocalMetadataSeriesAvailability(c *gc.C) {
+	s.makeLocalMetadata(c, "1234", "region-2", "raring", "some-auth-url", "")
+	s.makeLocalMetadata(c, "5678", "region-2", "trusty", "some-auth-url", "")
+	ctx := coretesting.Context(c)
+	code := cmd.Main(
+		envcmd.Wrap(&ValidateImageMetadataCommand{}), ctx, []string{
+			"-p", "openstack", "-s", "raring,trusty", "-r", "region-2",
+			"-u", "some-auth-url", "-d", s.metadataDir, "--format", "yaml"},
+	)
+	c.Assert(code, gc.Equals, 0)
+	c.Check(ctx.Stdout.(*bytes.Buffer).String(), gc.Equals, `
+Available:
+  raring:
+  - amd64
+  trusty:
+  - amd64
+Region: region-2
+`[1:])
+
+	ctx = coretesting.Context(c)
+	code = cmd.Main(
+		envcmd.Wrap(&ValidateImageMetadataCommand{}), ctx, []string{
+			"-p", "openstack", "-s", "raring,precise", "-r", "region-2",
+			"-u", "some-auth-url", "-d", s.metadataDir, "--format", "yaml"},
+	)
+	c.Assert(code, gc.Equals, 1)
+	c.Check(ctx.Stdout.(*bytes.Buffer).String(), gc.Equals, `
+Available:
+  precise: []
+  raring:
+  - amd64
+Region: region-2
+`[1:])
+	errOut := ctx.Stderr.(*bytes.Buffer).String()
+	c.Check(errOut, gc.Matches, "(?s).*no images for series precise in region region-2.*")
+}
@@ -5,13 +5,18 @@ package bootstrap
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/juju/errors"
 	"github.com/juju/loggo"
 	"github.com/juju/utils"
+	"golang.org/x/crypto/openpgp"
 
+	"github.com/juju/juju/agent"
 	"github.com/juju/juju/cloudconfig/instancecfg"
 	"github.com/juju/juju/constraints"
 	"github.com/juju/juju/environs"
@@ -20,6 +25,7 @@ import (
 	"github.com/juju/juju/environs/storage"
 	"github.com/juju/juju/environs/sync"
 	"github.com/juju/juju/environs/tools"
+	"github.com/juju/juju/instance"
 	"github.com/juju/juju/network"
 	coretools "github.com/juju/juju/tools"
 	"github.com/juju/juju/utils/ssh"
@@ -34,6 +40,10 @@ var (
 	logger = loggo.GetLogger("juju.environs.bootstrap")
 )
 
+// maxMongoOplogSizeMB is the largest mongo oplog size, in megabytes,
+// that may be requested at bootstrap.
+const maxMongoOplogSizeMB = 100 * 1024
+
 // BootstrapParams holds the parameters for bootstrapping an environment.
 type BootstrapParams struct {
 	// Constraints are used to choose the initial instance specification,
@@ -52,19 +62,90 @@ type BootstrapParams struct {
 	// tools and/or image metadata.
 	MetadataDir string
 
+	// MetadataPublicKey, if non-empty, holds an armored public key
+	// with which the tools metadata must be signed. Unsigned tools
+	// metadata is rejected.
+	MetadataPublicKey string
+
+	// BootstrapFiles holds additional files, such as CA certificate
+	// bundles, to write to the bootstrap instance before the state
+	// server is initialised.
+	BootstrapFiles []instancecfg.BootstrapFile
+
+	// MongoOplogSize, if non-zero, holds the size in megabytes of
+	// the state server's mongo oplog. By default the oplog is sized
+	// according to the free disk space.
+	MongoOplogSize int
+
 	// AgentVersion, if set, determines the exact tools version that
 	// will be used to start the Juju agents.
 	AgentVersion *version.Number
+
+	// PinAgentVersion, if true, sets the environment's agent-version
+	// to the version of the tools installed on the bootstrap instance,
+	// rather than to the newest available tools. The agents then stay
+	// on that version instead of immediately upgrading themselves.
+	PinAgentVersion bool
+
+	// KeepBroken, if true, ensures that the bootstrap instance is
+	// not destroyed if bootstrap fails after it has been started.
+	KeepBroken bool
+
+	// APIBindAddress, if non-empty, holds the IP address on which
+	// the state server's API server will listen. By default it
+	// listens on all interfaces.
+	APIBindAddress string
+
+	// ToolsStoragePath is an optional path within the environment's
+	// provider storage under which tools and their simplestreams
+	// metadata have already been uploaded. When set, it is searched
+	// for bootstrap tools before any other tools source.
+	ToolsStoragePath string
+
+	// DumpInstanceConfigPath, if non-empty, holds the path of a file
+	// to which the bootstrap instance config is written as YAML,
+	// with passwords and private keys redacted, before the bootstrap
+	// instance is configured. It allows what is written to the state
+	// server to be audited.
+	DumpInstanceConfigPath string
+}
+
+// BootstrapResult summarises the outcome of a successful bootstrap.
+type BootstrapResult struct {
+	// InstanceId holds the id of the bootstrap instance, if
+	// known.
+	InstanceId instance.Id
+
+	// Addresses holds the addresses of the bootstrap instance
+	// known to the provider when bootstrap completed.
+	Addresses []network.Address
+
+	// Series and Arch hold the series and architecture of the
+	// bootstrap instance.
+	Series string
+	Arch   string
+
+	// AgentVersion holds the version of the tools installed on
+	// the bootstrap instance.
+	AgentVersion version.Number
 }
 
 // Bootstrap bootstraps the given environment. The supplied constraints are
 // used to provision the instance, and are also set within the bootstrapped
 // environment.
 func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args BootstrapParams) error {
+	_, err := BootstrapWithResult(ctx, environ, args)
+	return err
+}
+
+// BootstrapWithResult is like Bootstrap, but also returns a summary
+// of the bootstrapped state server, suitable for reporting to tools
+// that script bootstrap.
+func BootstrapWithResult(ctx environs.BootstrapContext, environ environs.Environ, args BootstrapParams) (*BootstrapResult, error) {
 	cfg := environ.Config()
 	network.InitializeFromConfig(cfg)
 	if secret := cfg.AdminSecret(); secret == "" {
-		return errors.Errorf("environment configuration has no admin-secret")
+		return nil, errors.Errorf("environment configuration has no admin-secret")
 	}
 	if authKeys := ssh.SplitAuthorisedKeys(cfg.AuthorizedKeys()); len(authKeys) == 0 {
 		// Apparently this can never happen, so it's not tested. But, one day,
@@ -72,13 +153,36 @@ func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args Boo
 		// authorized-keys are optional config settings... but it's impossible
 		// to actually *create* a config without them)... and when it does,
 		// we'll be here to catch this problem early.
-		return errors.Errorf("environment configuration has no authorized-keys")
+		return nil, errors.Errorf("environment configuration has no authorized-keys")
 	}
 	if _, hasCACert := cfg.CACert(); !hasCACert {
-		return errors.Errorf("environment configuration has no ca-cert")
+		return nil, errors.Errorf("environment configuration has no ca-cert")
 	}
 	if _, hasCAKey := cfg.CAPrivateKey(); !hasCAKey {
-		return errors.Errorf("environment configuration has no ca-private-key")
+		return nil, errors.Errorf("environment configuration has no ca-private-key")
+	}
+	if args.APIBindAddress != "" && net.ParseIP(args.APIBindAddress) == nil {
+		return nil, errors.Errorf("invalid API bind address %q", args.APIBindAddress)
+	}
+	if args.MongoOplogSize < 0 || args.MongoOplogSize > maxMongoOplogSizeMB {
+		return nil, errors.Errorf("mongo oplog size %dMB out of range (0-%dMB, where 0 means the default)", args.MongoOplogSize, maxMongoOplogSizeMB)
+	}
+	for _, f := range args.BootstrapFiles {
+		if err := f.Validate(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if args.MetadataPublicKey != "" {
+		if _, err := openpgp.ReadArmoredKeyRing(strings.NewReader(args.MetadataPublicKey)); err != nil {
+			return nil, errors.Annotate(err, "invalid metadata public key")
+		}
+		logger.Infof("requiring tools metadata to be signed")
+		// The key applies only to finding tools for this bootstrap.
+		defer func(key string) {
+			tools.RequiredSigningKey = key
+		}(tools.RequiredSigningKey)
+		tools.RequiredSigningKey = args.MetadataPublicKey
 	}
 
 	// Set default tools metadata source, add image metadata source,
@@ -89,11 +193,18 @@ func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args Boo
 		var err error
 		imageMetadata, err = setPrivateMetadataSources(environ, args.MetadataDir)
 		if err != nil {
-			return err
+			return nil, err
+		}
+	}
+	if args.ToolsStoragePath != "" {
+		if err := setToolsStorageSource(environ, args.ToolsStoragePath); err != nil {
+			return nil, err
 		}
+		// The source is only needed to find the bootstrap tools.
+		defer tools.UnregisterToolsDataSourceFunc(toolsStorageSourceId)
 	}
 	if err := validateConstraints(environ, args.Constraints); err != nil {
-		return err
+		return nil, err
 	}
 
 	_, supportsNetworking := environs.SupportsNetworking(environ)
@@ -104,9 +215,9 @@ func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args Boo
 	logger.Debugf("network management by juju enabled: %v", !disableNetworkManagement)
 	availableTools, err := findAvailableTools(environ, args.AgentVersion, args.Constraints.Arch, args.UploadTools)
 	if errors.IsNotFound(err) {
-		return errors.New(noToolsMessage)
+		return nil, errors.New(noToolsMessage)
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 	if lxcMTU, ok := cfg.LXCDefaultMTU(); ok {
 		logger.Debugf("using MTU %v for all created LXC containers' network interfaces", lxcMTU)
@@ -124,10 +235,10 @@ func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args Boo
 	if cfg, err = cfg.Apply(map[string]interface{}{
 		"agent-version": agentVersion,
 	}); err != nil {
-		return err
+		return nil, err
 	}
 	if err = environ.SetConfig(cfg); err != nil {
-		return err
+		return nil, err
 	}
 
 	ctx.Infof("Starting new instance for initial state server")
@@ -135,9 +246,11 @@ func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args Boo
 		Constraints:    args.Constraints,
 		Placement:      args.Placement,
 		AvailableTools: availableTools,
+		KeepBroken:     args.KeepBroken,
+		APIBindAddress: args.APIBindAddress,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	matchingTools, err := availableTools.Match(coretools.Filter{
@@ -145,11 +258,11 @@ func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args Boo
 		Series: series,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	selectedTools, err := setBootstrapTools(environ, matchingTools)
+	selectedTools, err := setBootstrapTools(environ, matchingTools, args.PinAgentVersion)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if selectedTools.URL == "" {
 		if !args.UploadTools {
@@ -158,7 +271,7 @@ func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args Boo
 		ctx.Infof("Building tools to upload (%s)", selectedTools.Version)
 		builtTools, err := sync.BuildToolsTarball(&selectedTools.Version.Number, cfg.AgentStream())
 		if err != nil {
-			return errors.Annotate(err, "cannot upload bootstrap tools")
+			return nil, errors.Annotate(err, "cannot upload bootstrap tools")
 		}
 		defer os.RemoveAll(builtTools.Dir)
 		filename := filepath.Join(builtTools.Dir, builtTools.StorageName)
@@ -170,42 +283,43 @@ func Bootstrap(ctx environs.BootstrapContext, environ environs.Environ, args Boo
 	ctx.Infof("Installing Juju agent on bootstrap instance")
 	instanceConfig, err := instancecfg.NewBootstrapInstanceConfig(args.Constraints, series)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	instanceConfig.Tools = selectedTools
 	instanceConfig.CustomImageMetadata = imageMetadata
+	instanceConfig.BootstrapFiles = args.BootstrapFiles
+	if args.MongoOplogSize > 0 {
+		instanceConfig.AgentEnvironment[agent.MongoOplogSize] = strconv.Itoa(args.MongoOplogSize)
+	}
+	if args.DumpInstanceConfigPath != "" {
+		if err := dumpInstanceConfig(environ, instanceConfig, args.DumpInstanceConfigPath); err != nil {
+			return nil, errors.Annotate(err, "cannot dump bootstrap instance config")
+		}
+		logger.Infof("bootstrap instance config written to %q", args.DumpInstanceConfigPath)
+	}
 	if err := finalizer(ctx, instanceConfig); err != nil {
-		return err
+		return nil, err
 	}
 	ctx.Infof("Bootstrap agent installed")
-	return nil
+	return bootstrapResult(environ, instanceConfig, arch, series), nil
 }
 
-// setBootstrapTools returns the newest tools from the given tools list,
-// and updates the agent-version configuration attribute.
-func setBootstrapTools(environ environs.Environ, possibleTools coretools.List) (*coretools.Tools, error) {
+// setBootstrapTools returns the tools to bootstrap with from the given
+// tools list, and updates the agent-version configuration attribute.
+// Unless pinAgentVersion is true, agent-version is set to the newest
+// version in the list.
+func setBootstrapTools(environ environs.Environ, possibleTools coretools.List, pinAgentVersion bool) (*coretools.Tools, error) {
 	if len(possibleTools) == 0 {
 		return nil, fmt.Errorf("no bootstrap tools available")
 	}
 	var newVersion version.Number
 	newVersion, toolsList := possibleTools.Newest()
 	logger.Infof("newest version: %s", newVersion)
-	cfg := environ.Config()
-	if agentVersion, _ := cfg.AgentVersion(); agentVersion != newVersion {
-		cfg, err := cfg.Apply(map[string]interface{}{
-			"agent-version": newVersion.String(),
-		})
-		if err == nil {
-			err = environ.SetConfig(cfg)
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to update environment configuration: %v", err)
-		}
-	}
 	bootstrapVersion := newVersion
 	// We should only ever bootstrap the exact same version as the client,
 	// or we risk bootstrap incompatibility. We still set agent-version to
-	// the newest version, so the agent will immediately upgrade itself.
+	// the newest version, so the agent will immediately upgrade itself,
+	// unless the agent version is to be pinned.
 	if !isCompatibleVersion(newVersion, version.Current.Number) {
 		compatibleVersion, compatibleTools := findCompatibleTools(possibleTools, version.Current.Number)
 		if len(compatibleTools) == 0 {
@@ -218,6 +332,22 @@ func setBootstrapTools(environ environs.Environ, possibleTools coretools.List) (
 		}
 	}
 	logger.Infof("picked bootstrap tools version: %s", bootstrapVersion)
+	newAgentVersion := newVersion
+	if pinAgentVersion {
+		newAgentVersion = bootstrapVersion
+	}
+	cfg := environ.Config()
+	if agentVersion, _ := cfg.AgentVersion(); agentVersion != newAgentVersion {
+		cfg, err := cfg.Apply(map[string]interface{}{
+			"agent-version": newAgentVersion.String(),
+		})
+		if err == nil {
+			err = environ.SetConfig(cfg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update environment configuration: %v", err)
+		}
+	}
 	return toolsList[0], nil
 }
 
@@ -242,6 +372,58 @@ func isCompatibleVersion(v1, v2 version.Number) bool {
 	return v1.Compare(v2) == 0
 }
 
+// bootstrapResult returns a summary of the state server described by
+// the given instance config. Failing to find the instance's addresses
+// is not fatal, as the bootstrap itself has succeeded.
+func bootstrapResult(env environs.Environ, icfg *instancecfg.InstanceConfig, arch, series string) *BootstrapResult {
+	result := &BootstrapResult{
+		InstanceId:   icfg.InstanceId,
+		Series:       series,
+		Arch:         arch,
+		AgentVersion: icfg.Tools.Version.Number,
+	}
+	if result.InstanceId == "" {
+		return result
+	}
+	insts, err := env.Instances([]instance.Id{result.InstanceId})
+	if err == nil {
+		result.Addresses, err = insts[0].Addresses()
+	}
+	if err != nil {
+		logger.Warningf("cannot get addresses of bootstrap instance %q: %v", result.InstanceId, err)
+	}
+	return result
+}
+
+// toolsStorageSourceId identifies the tools datasource registered
+// for BootstrapParams.ToolsStoragePath.
+const toolsStorageSourceId = "bootstrap tools storage"
+
+// setToolsStorageSource registers the given path in the environment's
+// provider storage as a source of tools, after checking that the path
+// is accessible and not empty. The caller must unregister the source
+// when it is no longer needed.
+func setToolsStorageSource(env environs.Environ, storagePath string) error {
+	envStorage, ok := env.(environs.EnvironStorage)
+	if !ok {
+		return errors.NotSupportedf("tools storage path for environment without provider storage")
+	}
+	stor := envStorage.Storage()
+	names, err := stor.List(storagePath)
+	if err != nil {
+		return errors.Annotatef(err, "cannot access tools storage path %q", storagePath)
+	}
+	if len(names) == 0 {
+		return errors.NotFoundf("tools in storage path %q", storagePath)
+	}
+	logger.Infof("Setting tools metadata source: storage path %q", storagePath)
+	datasource := storage.NewStorageSimpleStreamsDataSource(toolsStorageSourceId, stor, storagePath)
+	tools.RegisterToolsDataSourceFunc(toolsStorageSourceId, func(environs.Environ) (simplestreams.DataSource, error) {
+		return datasource, nil
+	})
+	return nil
+}
+
 // setPrivateMetadataSources sets the default tools metadata source
 // for tools syncing, and adds an image metadata source after verifying
 // the contents.
@@ -5,6 +5,8 @@ package bootstrap_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"runtime"
 	"strings"
 	stdtesting "testing"
@@ -12,7 +14,9 @@ import (
 	"github.com/juju/errors"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
+	goyaml "gopkg.in/yaml.v1"
 
+	"github.com/juju/juju/agent"
 	"github.com/juju/juju/cloudconfig/instancecfg"
 	"github.com/juju/juju/constraints"
 	"github.com/juju/juju/environs"
@@ -21,10 +25,13 @@ import (
 	"github.com/juju/juju/environs/filestorage"
 	"github.com/juju/juju/environs/imagemetadata"
 	"github.com/juju/juju/environs/simplestreams"
+	sstesting "github.com/juju/juju/environs/simplestreams/testing"
 	"github.com/juju/juju/environs/storage"
 	envtesting "github.com/juju/juju/environs/testing"
 	envtools "github.com/juju/juju/environs/tools"
+	"github.com/juju/juju/instance"
 	"github.com/juju/juju/juju/arch"
+	"github.com/juju/juju/network"
 	"github.com/juju/juju/provider/dummy"
 	coretesting "github.com/juju/juju/testing"
 	"github.com/juju/juju/tools"
@@ -121,6 +128,115 @@ func (s *bootstrapSuite) TestBootstrapSpecifiedPlacement(c *gc.C) {
 	c.Assert(env.args.Placement, gc.DeepEquals, placement)
 }
 
+func (s *bootstrapSuite) TestBootstrapSpecifiedAPIBindAddress(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{APIBindAddress: "10.0.0.1"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(env.bootstrapCount, gc.Equals, 1)
+	c.Assert(env.args.APIBindAddress, gc.Equals, "10.0.0.1")
+}
+
+func (s *bootstrapSuite) TestBootstrapInvalidAPIBindAddress(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{APIBindAddress: "not-an-address"})
+	c.Assert(err, gc.ErrorMatches, `invalid API bind address "not-an-address"`)
+	c.Assert(env.bootstrapCount, gc.Equals, 0)
+}
+
+func (s *bootstrapSuite) TestBootstrapFiles(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	files := []instancecfg.BootstrapFile{{
+		Path:        "/usr/local/share/ca-certificates/cloud.crt",
+		Content:     "trust bundle",
+		Permissions: 0644,
+	}}
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{BootstrapFiles: files})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(env.bootstrapCount, gc.Equals, 1)
+	c.Assert(env.instanceConfig, gc.NotNil)
+	c.Assert(env.instanceConfig.BootstrapFiles, jc.DeepEquals, files)
+}
+
+func (s *bootstrapSuite) TestBootstrapFilesInvalidPath(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		BootstrapFiles: []instancecfg.BootstrapFile{{Path: "relative/cloud.crt"}},
+	})
+	c.Assert(err, gc.ErrorMatches, `bootstrap file path "relative/cloud.crt" is not a clean absolute path`)
+	c.Assert(env.bootstrapCount, gc.Equals, 0)
+}
+
+func (s *bootstrapSuite) TestBootstrapMongoOplogSize(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{MongoOplogSize: 2048})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(env.instanceConfig, gc.NotNil)
+	c.Assert(env.instanceConfig.AgentEnvironment[agent.MongoOplogSize], gc.Equals, "2048")
+}
+
+func (s *bootstrapSuite) TestBootstrapDumpInstanceConfig(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	path := filepath.Join(c.MkDir(), "instance-config.yaml")
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		DumpInstanceConfigPath: path,
+		BootstrapFiles: []instancecfg.BootstrapFile{{
+			Path:        "/etc/juju/secret.txt",
+			Content:     "do not dump",
+			Permissions: 0600,
+		}},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(env.finalizerCount, gc.Equals, 1)
+	// The config passed to the finalizer is left unfinished.
+	c.Assert(env.instanceConfig.APIInfo, gc.IsNil)
+
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, jc.ErrorIsNil)
+	var dump map[string]interface{}
+	err = goyaml.Unmarshal(data, &dump)
+	c.Assert(err, jc.ErrorIsNil)
+	for _, key := range []string{"api-password", "mongo-password", "state-server-key", "ca-private-key"} {
+		c.Check(dump[key], gc.Equals, "<redacted>", gc.Commentf("%s", key))
+	}
+	c.Check(dump["ca-cert"], gc.Equals, coretesting.CACert)
+	cfg := dump["config"].(map[interface{}]interface{})
+	c.Check(cfg["name"], gc.Equals, "foo")
+	c.Check(cfg["secret"], gc.Equals, "<redacted>")
+	for _, secret := range []string{
+		coretesting.DefaultMongoPassword,
+		coretesting.CAKey,
+		"pork",
+		"do not dump",
+	} {
+		c.Check(strings.Contains(string(data), secret), jc.IsFalse, gc.Commentf("%q found in dump", secret))
+	}
+}
+
+func (s *bootstrapSuite) TestBootstrapDumpInstanceConfigUnwritable(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	path := filepath.Join(c.MkDir(), "missing", "instance-config.yaml")
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		DumpInstanceConfigPath: path,
+	})
+	c.Assert(err, gc.ErrorMatches, "cannot dump bootstrap instance config: .*")
+	c.Assert(env.finalizerCount, gc.Equals, 0)
+}
+
+func (s *bootstrapSuite) TestBootstrapMongoOplogSizeOutOfRange(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{MongoOplogSize: -1})
+	c.Assert(err, gc.ErrorMatches, `mongo oplog size -1MB out of range \(0-102400MB, where 0 means the default\)`)
+	c.Assert(env.bootstrapCount, gc.Equals, 0)
+}
+
 func (s *bootstrapSuite) TestBootstrapNoToolsNonReleaseStream(c *gc.C) {
 	if runtime.GOOS == "windows" {
 		c.Skip("issue 1403084: Currently does not work because of jujud problems")
@@ -170,6 +286,7 @@ func (s *bootstrapSuite) TestSetBootstrapTools(c *gc.C) {
 
 	type test struct {
 		currentVersion       version.Number
+		pinAgentVersion      bool
 		expectedTools        version.Number
 		expectedAgentVersion version.Number
 	}
@@ -194,6 +311,13 @@ func (s *bootstrapSuite) TestSetBootstrapTools(c *gc.C) {
 		currentVersion:       version.MustParse("1.18.2"),
 		expectedTools:        version.MustParse("1.18.1.3"),
 		expectedAgentVersion: version.MustParse("1.18.1.3"),
+	}, {
+		// A pinned agent version is that of the bootstrap tools,
+		// so the agent will not upgrade itself.
+		currentVersion:       version.MustParse("1.18.0"),
+		pinAgentVersion:      true,
+		expectedTools:        version.MustParse("1.18.0"),
+		expectedAgentVersion: version.MustParse("1.18.0"),
 	}}
 
 	env := newEnviron("foo", useDefaultKeys, nil)
@@ -204,7 +328,7 @@ func (s *bootstrapSuite) TestSetBootstrapTools(c *gc.C) {
 		err = env.SetConfig(cfg)
 		c.Assert(err, jc.ErrorIsNil)
 		s.PatchValue(&version.Current.Number, t.currentVersion)
-		bootstrapTools, err := bootstrap.SetBootstrapTools(env, availableTools)
+		bootstrapTools, err := bootstrap.SetBootstrapTools(env, availableTools, t.pinAgentVersion)
 		c.Assert(err, jc.ErrorIsNil)
 		c.Assert(bootstrapTools.Version.Number, gc.Equals, t.expectedTools)
 		agentVersion, _ := env.Config().AgentVersion()
@@ -260,6 +384,33 @@ func (s *bootstrapSuite) TestBootstrapMetadata(c *gc.C) {
 	c.Assert(env.instanceConfig.CustomImageMetadata[0], gc.DeepEquals, metadata[0])
 }
 
+func (s *bootstrapSuite) TestBootstrapMetadataPublicKeyInvalid(c *gc.C) {
+	s.PatchValue(&envtools.RequiredSigningKey, "")
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		MetadataPublicKey: "not a key",
+	})
+	c.Assert(err, gc.ErrorMatches, "invalid metadata public key: .*")
+	c.Assert(env.bootstrapCount, gc.Equals, 0)
+	c.Assert(envtools.RequiredSigningKey, gc.Equals, "")
+}
+
+func (s *bootstrapSuite) TestBootstrapMetadataPublicKeyRejectsUnsignedTools(c *gc.C) {
+	s.PatchValue(&envtools.RequiredSigningKey, "")
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	// The fake tools uploaded in SetUpTest are not signed.
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		AgentVersion:      &version.Current.Number,
+		MetadataPublicKey: sstesting.SignedMetadataPublicKey,
+	})
+	c.Assert(strings.Replace(err.Error(), "\n", "", -1), gc.Matches, ".* no tools are available .*")
+	c.Assert(env.bootstrapCount, gc.Equals, 0)
+	// The key does not outlive the bootstrap.
+	c.Assert(envtools.RequiredSigningKey, gc.Equals, "")
+}
+
 func (s *bootstrapSuite) TestBootstrapMetadataImagesMissing(c *gc.C) {
 	environs.UnregisterImageDataSourceFunc("bootstrap metadata")
 
@@ -282,6 +433,61 @@ func (s *bootstrapSuite) TestBootstrapMetadataImagesMissing(c *gc.C) {
 	c.Assert(datasources[0].Description(), gc.Equals, "default cloud images")
 }
 
+func (s *bootstrapSuite) TestBootstrapWithResult(c *gc.C) {
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	env.inst = &bootstrapInstance{
+		id:        "i-bootstrap",
+		addresses: network.NewAddresses("10.0.0.1"),
+	}
+	result, err := bootstrap.BootstrapWithResult(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(result, jc.DeepEquals, &bootstrap.BootstrapResult{
+		InstanceId:   "i-bootstrap",
+		Addresses:    network.NewAddresses("10.0.0.1"),
+		Series:       version.Current.Series,
+		Arch:         arch.HostArch(),
+		AgentVersion: env.instanceConfig.Tools.Version.Number,
+	})
+}
+
+func (s *bootstrapSuite) TestBootstrapToolsStoragePath(c *gc.C) {
+	s.AddCleanup(func(*gc.C) { envtools.UnregisterToolsDataSourceFunc("bootstrap tools storage") })
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+	envtesting.UploadFakeTools(c, env.storage, "released", "released")
+
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		ToolsStoragePath: "tools",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(env.bootstrapCount, gc.Equals, 1)
+	// The tools came from provider storage rather than the default
+	// source.
+	toolsURL, err := env.storage.URL("tools")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(env.instanceConfig.Tools.URL, jc.HasPrefix, toolsURL)
+
+	// The source is unregistered once bootstrap is done.
+	datasources, err := envtools.GetMetadataSources(env)
+	c.Assert(err, jc.ErrorIsNil)
+	for _, source := range datasources {
+		c.Check(source.Description(), gc.Not(gc.Equals), "bootstrap tools storage")
+	}
+}
+
+func (s *bootstrapSuite) TestBootstrapToolsStoragePathEmpty(c *gc.C) {
+	s.AddCleanup(func(*gc.C) { envtools.UnregisterToolsDataSourceFunc("bootstrap tools storage") })
+	env := newEnviron("foo", useDefaultKeys, nil)
+	s.setDummyStorage(c, env)
+
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		ToolsStoragePath: "no-tools-here",
+	})
+	c.Assert(err, gc.ErrorMatches, `tools in storage path "no-tools-here" not found`)
+	c.Assert(env.bootstrapCount, gc.Equals, 0)
+}
+
 func (s *bootstrapSuite) setupBootstrapSpecificVersion(
 	c *gc.C, clientMajor, clientMinor int, toolsVersion *version.Number,
 ) (error, int, version.Number) {
@@ -387,6 +593,24 @@ type bootstrapEnviron struct {
 	args                        environs.BootstrapParams
 	instanceConfig              *instancecfg.InstanceConfig
 	storage                     storage.Storage
+
+	// If inst is set, the finalizer records its id in the
+	// instance config, and Instances returns it.
+	inst *bootstrapInstance
+}
+
+type bootstrapInstance struct {
+	instance.Instance // stub out all methods we don't care about.
+	id                instance.Id
+	addresses         []network.Address
+}
+
+func (inst *bootstrapInstance) Id() instance.Id {
+	return inst.id
+}
+
+func (inst *bootstrapInstance) Addresses() ([]network.Address, error) {
+	return inst.addresses, nil
 }
 
 func newEnviron(name string, defaultKeys bool, extraAttrs map[string]interface{}) *bootstrapEnviron {
@@ -423,11 +647,21 @@ func (e *bootstrapEnviron) Bootstrap(ctx environs.BootstrapContext, args environ
 	finalizer := func(_ environs.BootstrapContext, icfg *instancecfg.InstanceConfig) error {
 		e.finalizerCount++
 		e.instanceConfig = icfg
+		if e.inst != nil {
+			icfg.InstanceId = e.inst.id
+		}
 		return nil
 	}
 	return arch.HostArch(), version.Current.Series, finalizer, nil
 }
 
+func (e *bootstrapEnviron) Instances(ids []instance.Id) ([]instance.Instance, error) {
+	if e.inst == nil || len(ids) != 1 || ids[0] != e.inst.id {
+		return nil, environs.ErrNoInstances
+	}
+	return []instance.Instance{e.inst}, nil
+}
+
 func (e *bootstrapEnviron) Config() *config.Config {
 	return e.cfg
 }
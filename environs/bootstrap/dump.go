@@ -0,0 +1,147 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/juju/errors"
+	goyaml "gopkg.in/yaml.v1"
+
+	"github.com/juju/juju/cloudconfig/instancecfg"
+	"github.com/juju/juju/environs"
+)
+
+// redacted replaces secret values in an instance config dump.
+const redacted = "<redacted>"
+
+// instanceConfigDump holds the parts of a bootstrap instance config
+// written out for audit, with any secrets redacted.
+type instanceConfigDump struct {
+	Series           string                 `yaml:"series"`
+	Jobs             []string               `yaml:"jobs"`
+	Tools            string                 `yaml:"tools"`
+	ToolsURL         string                 `yaml:"tools-url"`
+	DataDir          string                 `yaml:"data-dir"`
+	LogDir           string                 `yaml:"log-dir"`
+	Constraints      string                 `yaml:"constraints,omitempty"`
+	AuthorizedKeys   string                 `yaml:"authorized-keys"`
+	AgentEnvironment map[string]string      `yaml:"agent-environment,omitempty"`
+	StatePort        int                    `yaml:"state-port"`
+	APIPort          int                    `yaml:"api-port"`
+	APIPassword      string                 `yaml:"api-password"`
+	MongoPassword    string                 `yaml:"mongo-password"`
+	CACert           string                 `yaml:"ca-cert"`
+	StateServerCert  string                 `yaml:"state-server-cert"`
+	StateServerKey   string                 `yaml:"state-server-key"`
+	CAPrivateKey     string                 `yaml:"ca-private-key"`
+	SharedSecret     string                 `yaml:"shared-secret"`
+	SystemIdentity   string                 `yaml:"system-identity"`
+	BootstrapFiles   []bootstrapFileDump    `yaml:"bootstrap-files,omitempty"`
+	Config           map[string]interface{} `yaml:"config"`
+}
+
+// bootstrapFileDump describes a bootstrap file without its content.
+type bootstrapFileDump struct {
+	Path        string `yaml:"path"`
+	Permissions string `yaml:"permissions"`
+	Size        int    `yaml:"size"`
+}
+
+// dumpInstanceConfig writes the bootstrap instance config, as it will
+// be once finished for the environment, to the file at path with all
+// passwords and private keys redacted. The config is finished on a
+// copy, so the given config is unchanged; the state server certificate
+// in the dump is therefore not the one that will be installed.
+func dumpInstanceConfig(env environs.Environ, icfg *instancecfg.InstanceConfig, path string) error {
+	preview := *icfg
+	preview.AgentEnvironment = make(map[string]string)
+	for k, v := range icfg.AgentEnvironment {
+		preview.AgentEnvironment[k] = v
+	}
+	if err := instancecfg.FinishInstanceConfig(&preview, env.Config()); err != nil {
+		return errors.Trace(err)
+	}
+	dump, err := redactInstanceConfig(&preview)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := goyaml.Marshal(dump)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// redactInstanceConfig returns the dump of the given finished
+// instance config.
+func redactInstanceConfig(icfg *instancecfg.InstanceConfig) (*instanceConfigDump, error) {
+	dump := &instanceConfigDump{
+		Series:           icfg.Series,
+		DataDir:          icfg.DataDir,
+		LogDir:           icfg.LogDir,
+		Constraints:      icfg.Constraints.String(),
+		AuthorizedKeys:   icfg.AuthorizedKeys,
+		AgentEnvironment: icfg.AgentEnvironment,
+	}
+	for _, job := range icfg.Jobs {
+		dump.Jobs = append(dump.Jobs, string(job))
+	}
+	if icfg.Tools != nil {
+		dump.Tools = icfg.Tools.Version.String()
+		dump.ToolsURL = icfg.Tools.URL
+	}
+	if icfg.APIInfo != nil {
+		dump.APIPassword = redact(icfg.APIInfo.Password)
+		dump.CACert = icfg.APIInfo.CACert
+	}
+	if icfg.MongoInfo != nil {
+		dump.MongoPassword = redact(icfg.MongoInfo.Password)
+	}
+	if info := icfg.StateServingInfo; info != nil {
+		dump.StatePort = info.StatePort
+		dump.APIPort = info.APIPort
+		dump.StateServerCert = info.Cert
+		dump.StateServerKey = redact(info.PrivateKey)
+		dump.CAPrivateKey = redact(info.CAPrivateKey)
+		dump.SharedSecret = redact(info.SharedSecret)
+		dump.SystemIdentity = redact(info.SystemIdentity)
+	}
+	for _, f := range icfg.BootstrapFiles {
+		dump.BootstrapFiles = append(dump.BootstrapFiles, bootstrapFileDump{
+			Path:        f.Path,
+			Permissions: fmt.Sprintf("%#o", f.Permissions),
+			Size:        len(f.Content),
+		})
+	}
+	if icfg.Config != nil {
+		provider, err := environs.Provider(icfg.Config.Type())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		secrets, err := provider.SecretAttrs(icfg.Config)
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot get secret attributes")
+		}
+		dump.Config = icfg.Config.AllAttrs()
+		for _, name := range []string{"admin-secret", "ca-private-key"} {
+			if _, ok := dump.Config[name]; ok {
+				dump.Config[name] = redacted
+			}
+		}
+		for name := range secrets {
+			dump.Config[name] = redacted
+		}
+	}
+	return dump, nil
+}
+
+// redact returns the redacted placeholder for a non-empty secret.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
@@ -1425,6 +1425,26 @@ func (cfg *Config) ValidateUnknownAttrs(fields schema.Fields, defaults schema.De
 // GenerateStateServerCertAndKey makes sure that the config has a CACert and
 // CAPrivateKey, generates and returns new certificate and key.
 func (cfg *Config) GenerateStateServerCertAndKey(hostAddresses []string) (string, string, error) {
+	caCert, caKey, err := cfg.caCertAndKey()
+	if err != nil {
+		return "", "", err
+	}
+	return cert.NewDefaultServer(caCert, caKey, hostAddresses)
+}
+
+// ReissueStateServerCertAndKey makes sure that the config has a CACert
+// and CAPrivateKey, and returns a new state server certificate and key
+// valid for the host names and addresses of the given existing
+// certificate as well as for extraHostAddresses.
+func (cfg *Config) ReissueStateServerCertAndKey(serverCert string, extraHostAddresses []string) (string, string, error) {
+	caCert, caKey, err := cfg.caCertAndKey()
+	if err != nil {
+		return "", "", err
+	}
+	return cert.ReissueServer(caCert, caKey, serverCert, extraHostAddresses)
+}
+
+func (cfg *Config) caCertAndKey() (string, string, error) {
 	caCert, hasCACert := cfg.CACert()
 	if !hasCACert {
 		return "", "", fmt.Errorf("environment configuration has no ca-cert")
@@ -1433,7 +1453,7 @@ func (cfg *Config) GenerateStateServerCertAndKey(hostAddresses []string) (string
 	if !hasCAKey {
 		return "", "", fmt.Errorf("environment configuration has no ca-private-key")
 	}
-	return cert.NewDefaultServer(caCert, caKey, hostAddresses)
+	return caCert, caKey, nil
 }
 
 // SpecializeCharmRepo customizes a repository for a given configuration.
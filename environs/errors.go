@@ -13,6 +13,11 @@ var (
 	ErrNoInstances         = errors.NotFoundf("instances")
 	ErrPartialInstances    = errors.New("only some instances were found")
 
+	// ErrEnvironStopped is returned by Environ.Destroy when the
+	// environment's instances were shut down rather than removed, so
+	// that the environment can be destroyed for good later.
+	ErrEnvironStopped = errors.New("environment stopped but not removed")
+
 	// Errors indicating that the provider can't allocate an IP address to an
 	// instance.
 	ErrIPAddressesExhausted = errors.New("can't allocate a new IP address")
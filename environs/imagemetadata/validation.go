@@ -6,6 +6,8 @@ package imagemetadata
 import (
 	"fmt"
 
+	"github.com/juju/errors"
+
 	"github.com/juju/juju/environs/simplestreams"
 )
 
@@ -49,3 +51,47 @@ func ValidateImageMetadata(params *simplestreams.MetadataLookupParams) ([]string
 	}
 	return image_ids, resolveInfo, nil
 }
+
+// ImageAvailability records whether image metadata is available for a
+// series and architecture.
+type ImageAvailability struct {
+	Series    string
+	Arch      string
+	Available bool
+}
+
+// CheckImageAvailability reports, for every combination of the given
+// series and architectures, whether the sources hold image metadata
+// for the cloud and stream. The results are ordered by series and then
+// by architecture, as given. Sources that have no metadata for the
+// cloud at all count as having no images rather than as an error.
+func CheckImageAvailability(
+	sources []simplestreams.DataSource, cloudSpec simplestreams.CloudSpec,
+	stream string, series, arches []string,
+) ([]ImageAvailability, error) {
+	var results []ImageAvailability
+	for _, s := range series {
+		imageConstraint := NewImageConstraint(simplestreams.LookupParams{
+			CloudSpec: cloudSpec,
+			Series:    []string{s},
+			Arches:    arches,
+			Stream:    stream,
+		})
+		matchingImages, _, err := Fetch(sources, imageConstraint, false)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, errors.Annotatef(err, "cannot fetch image metadata for series %q", s)
+		}
+		found := make(map[string]bool)
+		for _, im := range matchingImages {
+			found[im.Arch] = true
+		}
+		for _, arch := range arches {
+			results = append(results, ImageAvailability{
+				Series:    s,
+				Arch:      arch,
+				Available: found[arch],
+			})
+		}
+	}
+	return results, nil
+}
@@ -99,3 +99,37 @@ func (s *ValidateSuite) TestNoMatch(c *gc.C) {
 	s.assertNoMatch(c, imagemetadata.ReleasedStream)
 	s.assertNoMatch(c, "daily")
 }
+
+func (s *ValidateSuite) TestCheckImageAvailability(c *gc.C) {
+	s.makeLocalMetadata(c, "1234", "region-2", "raring", "some-auth-url", "")
+	s.makeLocalMetadata(c, "5678", "region-2", "trusty", "some-auth-url", "")
+	sources := []simplestreams.DataSource{
+		simplestreams.NewURLDataSource("test", utils.MakeFileURL(filepath.Join(s.metadataDir, "images")), utils.VerifySSLHostnames),
+	}
+	cloudSpec := simplestreams.CloudSpec{Region: "region-2", Endpoint: "some-auth-url"}
+	results, err := imagemetadata.CheckImageAvailability(
+		sources, cloudSpec, "", []string{"raring", "precise", "trusty"}, []string{"amd64", "arm64"},
+	)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(results, jc.DeepEquals, []imagemetadata.ImageAvailability{
+		{Series: "raring", Arch: "amd64", Available: true},
+		{Series: "raring", Arch: "arm64", Available: false},
+		{Series: "precise", Arch: "amd64", Available: false},
+		{Series: "precise", Arch: "arm64", Available: false},
+		{Series: "trusty", Arch: "amd64", Available: true},
+		{Series: "trusty", Arch: "arm64", Available: false},
+	})
+}
+
+func (s *ValidateSuite) TestCheckImageAvailabilityNoMetadataForCloud(c *gc.C) {
+	s.makeLocalMetadata(c, "1234", "region-2", "raring", "some-auth-url", "")
+	sources := []simplestreams.DataSource{
+		simplestreams.NewURLDataSource("test", utils.MakeFileURL(filepath.Join(s.metadataDir, "images")), utils.VerifySSLHostnames),
+	}
+	cloudSpec := simplestreams.CloudSpec{Region: "other-region", Endpoint: "some-auth-url"}
+	results, err := imagemetadata.CheckImageAvailability(sources, cloudSpec, "", []string{"raring"}, []string{"amd64"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(results, jc.DeepEquals, []imagemetadata.ImageAvailability{
+		{Series: "raring", Arch: "amd64", Available: false},
+	})
+}
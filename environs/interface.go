@@ -98,6 +98,22 @@ type BootstrapParams struct {
 	// network bridge device to use for LXC and KVM containers. See
 	// also instancecfg.DefaultBridgeName.
 	ContainerBridgeName string
+
+	// KeepBroken, if true, prevents the bootstrap instance from being
+	// destroyed if the BootstrapFinalizer fails, so that it may be
+	// inspected to diagnose the failure.
+	KeepBroken bool
+
+	// APIBindAddress, if non-empty, holds the IP address on which
+	// the bootstrap state server's API server will listen. If empty,
+	// the API server listens on all interfaces.
+	APIBindAddress string
+
+	// NoProxyHosts holds additional hosts that the bootstrap
+	// instance should access directly, bypassing any configured
+	// proxy. They are added to any no-proxy setting in the
+	// environment configuration.
+	NoProxyHosts []string
 }
 
 // BootstrapFinalizer is a function returned from Environ.Bootstrap.
@@ -174,6 +190,9 @@ type Environ interface {
 	//
 	// When Destroy has been called, any Environ referring to the
 	// same remote environment may become invalid
+	//
+	// A provider that only shuts the environment down, leaving its
+	// resources to be removed by a later call, returns ErrEnvironStopped.
 	Destroy() error
 
 	// OpenPorts opens the given port ranges for the whole environment.
@@ -206,6 +225,16 @@ type InstanceTagger interface {
 	TagInstance(id instance.Id, tags map[string]string) error
 }
 
+// FloatingIPReconciler is an interface that can be implemented by
+// an Environ whose instances may be left with floating IPs that were
+// allocated for them but never assigned.
+type FloatingIPReconciler interface {
+	// ReconcileFloatingIPs assigns or releases the floating IPs left
+	// unassigned. It is called by the environment provisioner when it
+	// starts, before it starts any instances.
+	ReconcileFloatingIPs() error
+}
+
 // BootstrapContext is an interface that is passed to
 // Environ.Bootstrap, providing a means of obtaining
 // information about and manipulating the context in which
@@ -291,9 +291,14 @@ func ensureUUID(cfg *config.Config) (*config.Config, error) {
 
 // Destroy destroys the environment and, if successful,
 // its associated configuration data from the given store.
+// If the environment was only stopped, the configuration
+// data is kept so that it can be destroyed again later.
 func Destroy(env Environ, store configstore.Storage) error {
 	name := env.Config().Name()
-	if err := env.Destroy(); err != nil {
+	if err := env.Destroy(); err == ErrEnvironStopped {
+		logger.Infof("environment %q stopped; keeping its configuration data", name)
+		return nil
+	} else if err != nil {
 		return err
 	}
 	return DestroyInfo(name, store)
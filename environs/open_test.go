@@ -326,6 +326,40 @@ func (*OpenSuite) TestDestroy(c *gc.C) {
 	c.Assert(err, jc.Satisfies, errors.IsNotFound)
 }
 
+type stoppingEnviron struct {
+	environs.Environ
+}
+
+func (stoppingEnviron) Destroy() error {
+	return environs.ErrEnvironStopped
+}
+
+func (*OpenSuite) TestDestroyStoppedKeepsInfo(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, dummy.SampleConfig().Merge(
+		testing.Attrs{
+			"state-server": false,
+			"name":         "erewhemos",
+		},
+	))
+	c.Assert(err, jc.ErrorIsNil)
+
+	store := configstore.NewMem()
+	ctx := envtesting.BootstrapContext(c)
+	e, err := environs.Prepare(cfg, ctx, store)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = environs.Destroy(stoppingEnviron{e}, store)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = store.ReadInfo(e.Config().Name())
+	c.Assert(err, jc.ErrorIsNil)
+
+	// Destroying the environment for good removes the info.
+	err = environs.Destroy(e, store)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = store.ReadInfo(e.Config().Name())
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
 func (*OpenSuite) TestNewFromAttrs(c *gc.C) {
 	e, err := environs.NewFromAttrs(dummy.SampleConfig().Merge(
 		testing.Attrs{
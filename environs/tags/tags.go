@@ -21,6 +21,10 @@ const (
 	// the units deployed to a machine instance.
 	JujuUnitsDeployed = JujuTagPrefix + "units-deployed"
 
+	// JujuServicesDeployed is the tag name used for identifying
+	// the services whose units are deployed to a machine instance.
+	JujuServicesDeployed = JujuTagPrefix + "services-deployed"
+
 	// JujuStorageInstance is the tag name used for identifying
 	// the Juju storage instance that an IaaS storage resource
 	// is assigned to.
@@ -215,14 +215,24 @@ func (t *ToolsMetadata) productId() (string, error) {
 	return fmt.Sprintf("com.ubuntu.juju:%s:%s", seriesVersion, t.Arch), nil
 }
 
+// RequiredSigningKey, if set, holds an armored public key with which all
+// tools metadata must be signed. Unsigned metadata, and metadata signed
+// with any other key, is then rejected.
+var RequiredSigningKey string
+
 // Fetch returns a list of tools for the specified cloud matching the constraint.
 // The base URL locations are as specified - the first location which has a file is the one used.
 // Signed data is preferred, but if there is no signed data available and onlySigned is false,
-// then unsigned data is used.
+// then unsigned data is used. If RequiredSigningKey is set, only data signed with that key is used.
 func Fetch(
 	sources []simplestreams.DataSource, cons *ToolsConstraint,
 	onlySigned bool) ([]*ToolsMetadata, *simplestreams.ResolveInfo, error) {
 
+	publicKey := simplestreamsToolsPublicKey
+	if RequiredSigningKey != "" {
+		publicKey = RequiredSigningKey
+		onlySigned = true
+	}
 	params := simplestreams.GetMetadataParams{
 		StreamsVersion:   currentStreamsVersion,
 		OnlySigned:       onlySigned,
@@ -232,7 +242,7 @@ func Fetch(
 			FilterFunc:      appendMatchingTools,
 			MirrorContentId: ToolsContentId(cons.Stream),
 			ValueTemplate:   ToolsMetadata{},
-			PublicKey:       simplestreamsToolsPublicKey,
+			PublicKey:       publicKey,
 		},
 	}
 	items, resolveInfo, err := simplestreams.GetMetadata(sources, params)
@@ -18,6 +18,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/juju/errors"
 	jc "github.com/juju/testing/checkers"
 	"github.com/juju/utils"
 	"gopkg.in/amz.v3/aws"
@@ -1073,6 +1074,40 @@ func (s *signedSuite) TestSignedToolsMetadata(c *gc.C) {
 	})
 }
 
+func (s *signedSuite) TestRequiredSigningKeyAcceptsSigned(c *gc.C) {
+	// Only the required key can verify the metadata.
+	origKey := tools.SetSigningPublicKey("")
+	defer tools.SetSigningPublicKey(origKey)
+	tools.RequiredSigningKey = sstesting.SignedMetadataPublicKey
+	defer func() { tools.RequiredSigningKey = "" }()
+
+	signedSource := simplestreams.NewURLDataSource("test", "signedtest://host/signed", utils.VerifySSLHostnames)
+	toolsMetadata, resolveInfo, err := tools.Fetch(
+		[]simplestreams.DataSource{signedSource}, s.toolsConstraint(), false)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(toolsMetadata, gc.HasLen, 1)
+	c.Assert(resolveInfo.Signed, jc.IsTrue)
+}
+
+func (s *signedSuite) TestRequiredSigningKeyRejectsUnsigned(c *gc.C) {
+	tools.RequiredSigningKey = sstesting.SignedMetadataPublicKey
+	defer func() { tools.RequiredSigningKey = "" }()
+
+	unsignedSource := simplestreams.NewURLDataSource("test", "signedtest://host/unsigned", utils.VerifySSLHostnames)
+	_, _, err := tools.Fetch(
+		[]simplestreams.DataSource{unsignedSource}, s.toolsConstraint(), false)
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *signedSuite) toolsConstraint() *tools.ToolsConstraint {
+	return tools.NewVersionedToolsConstraint(version.MustParse("1.13.0"), simplestreams.LookupParams{
+		CloudSpec: simplestreams.CloudSpec{"us-east-1", "https://ec2.us-east-1.amazonaws.com"},
+		Series:    []string{"precise"},
+		Arches:    []string{"amd64"},
+		Stream:    "released",
+	})
+}
+
 var unsignedIndex = `
 {
  "index": {
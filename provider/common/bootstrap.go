@@ -61,7 +61,9 @@ func BootstrapInstance(ctx environs.BootstrapContext, env environs.Environ, args
 	// First thing, ensure we have tools otherwise there's no point.
 	series = config.PreferredSeries(env.Config())
 	availableTools, err := args.AvailableTools.Match(coretools.Filter{Series: series})
-	if err != nil {
+	if err == coretools.ErrNoMatches {
+		return nil, "", nil, errors.Errorf("no tools available for series %q", series)
+	} else if err != nil {
 		return nil, "", nil, err
 	}
 
@@ -107,18 +109,69 @@ func BootstrapInstance(ctx environs.BootstrapContext, env environs.Environ, args
 	}
 	fmt.Fprintf(ctx.GetStderr(), " - %s\n", result.Instance.Id())
 
-	finalize := func(ctx environs.BootstrapContext, icfg *instancecfg.InstanceConfig) error {
+	finalize := func(ctx environs.BootstrapContext, icfg *instancecfg.InstanceConfig) (err error) {
+		defer func() {
+			if err != nil {
+				handleBootstrapFinalizerError(ctx, env, result.Instance, args.KeepBroken)
+			}
+		}()
 		icfg.InstanceId = result.Instance.Id()
 		icfg.HardwareCharacteristics = result.Hardware
 		if err := instancecfg.FinishInstanceConfig(icfg, env.Config()); err != nil {
 			return err
 		}
+		if args.APIBindAddress != "" {
+			icfg.StateServingInfo.APIBindAddress = args.APIBindAddress
+		}
+		icfg.ProxySettings.NoProxy = addNoProxyHosts(icfg.ProxySettings.NoProxy, args.NoProxyHosts)
 		maybeSetBridge(icfg)
 		return FinishBootstrap(ctx, client, result.Instance, icfg)
 	}
 	return result, series, finalize, nil
 }
 
+// addNoProxyHosts returns the comma-separated no-proxy value with
+// any of the given hosts not already present appended to it.
+func addNoProxyHosts(noProxy string, hosts []string) string {
+	var entries []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(noProxy, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" && !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	for _, host := range hosts {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			entries = append(entries, host)
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// handleBootstrapFinalizerError is called when finalizing the bootstrap
+// instance fails. Unless keepBroken is true the instance is stopped;
+// otherwise its id and addresses are reported so that it can be
+// inspected to diagnose the failure.
+func handleBootstrapFinalizerError(ctx environs.BootstrapContext, env environs.Environ, inst instance.Instance, keepBroken bool) {
+	if !keepBroken {
+		if err := env.StopInstances(inst.Id()); err != nil {
+			logger.Errorf("cannot stop failed bootstrap instance %q: %v", inst.Id(), err)
+		}
+		return
+	}
+	fmt.Fprintf(ctx.GetStderr(), "Bootstrap failed; keeping instance %s for diagnosis\n", inst.Id())
+	addrs, err := inst.Addresses()
+	if err != nil {
+		logger.Warningf("cannot get addresses of bootstrap instance %q: %v", inst.Id(), err)
+		return
+	}
+	for _, addr := range addrs {
+		fmt.Fprintf(ctx.GetStderr(), " - %s\n", addr.Value)
+	}
+}
+
 // FinishBootstrap completes the bootstrap process by connecting
 // to the instance via SSH and carrying out the cloud-config.
 //
@@ -124,6 +124,28 @@ func (s *BootstrapSuite) TestCannotStartInstance(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, "cannot start bootstrap instance: meh, not started")
 }
 
+func (s *BootstrapSuite) TestNoToolsForSeries(c *gc.C) {
+	s.PatchValue(&version.Current.Number, coretesting.FakeVersionNumber)
+	env := &mockEnviron{
+		storage: newStorage(s, c),
+		config:  configGetter(c),
+		startInstance: func(
+			string, constraints.Value, []string, tools.List, *instancecfg.InstanceConfig,
+		) (instance.Instance, *instance.HardwareCharacteristics, []network.InterfaceInfo, error) {
+			c.Fatalf("instance should not be started")
+			return nil, nil, nil, nil
+		},
+	}
+	otherVersion := version.Current
+	otherVersion.Series = "no-such-series"
+
+	ctx := envtesting.BootstrapContext(c)
+	_, _, _, err := common.Bootstrap(ctx, env, environs.BootstrapParams{
+		AvailableTools: tools.List{&tools.Tools{Version: otherVersion}},
+	})
+	c.Assert(err, gc.ErrorMatches, fmt.Sprintf("no tools available for series %q", version.Current.Series))
+}
+
 func (s *BootstrapSuite) TestSuccess(c *gc.C) {
 	s.PatchValue(&version.Current.Number, coretesting.FakeVersionNumber)
 	stor := newStorage(s, c)
@@ -163,6 +185,59 @@ func (s *BootstrapSuite) TestSuccess(c *gc.C) {
 	c.Assert(series, gc.Equals, config.PreferredSeries(mocksConfig))
 }
 
+func (s *BootstrapSuite) assertFinalizerFailure(c *gc.C, keepBroken bool) (stopped []instance.Id, stderr string) {
+	s.PatchValue(&version.Current.Number, coretesting.FakeVersionNumber)
+	s.PatchValue(&common.FinishBootstrap, func(environs.BootstrapContext, ssh.Client, instance.Instance, *instancecfg.InstanceConfig) error {
+		return fmt.Errorf("meh, not finished")
+	})
+	startInstance := func(
+		_ string, _ constraints.Value, _ []string, _ tools.List, icfg *instancecfg.InstanceConfig,
+	) (
+		instance.Instance, *instance.HardwareCharacteristics, []network.InterfaceInfo, error,
+	) {
+		inst := &mockInstance{
+			id:        "i-broken",
+			addresses: network.NewAddresses("10.0.0.1"),
+		}
+		hw := instance.MustParseHardware("arch=amd64")
+		return inst, &hw, nil, nil
+	}
+	cfg, err := minimalConfig(c).Apply(map[string]interface{}{"admin-secret": "sekrit"})
+	c.Assert(err, jc.ErrorIsNil)
+	env := &mockEnviron{
+		storage:       newStorage(s, c),
+		startInstance: startInstance,
+		stopInstances: func(ids []instance.Id) error {
+			stopped = append(stopped, ids...)
+			return nil
+		},
+		config: func() *config.Config { return cfg },
+	}
+	ctx := coretesting.Context(c)
+	_, series, finalizer, err := common.Bootstrap(envcmd.BootstrapContext(ctx), env, environs.BootstrapParams{
+		AvailableTools: tools.List{&tools.Tools{Version: version.Current}},
+		KeepBroken:     keepBroken,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	icfg, err := instancecfg.NewBootstrapInstanceConfig(constraints.Value{}, series)
+	c.Assert(err, jc.ErrorIsNil)
+	icfg.Tools = &tools.Tools{Version: version.Current, URL: "http://testing.invalid/tools.tar.gz"}
+	err = finalizer(envcmd.BootstrapContext(ctx), icfg)
+	c.Assert(err, gc.ErrorMatches, "meh, not finished")
+	return stopped, coretesting.Stderr(ctx)
+}
+
+func (s *BootstrapSuite) TestFinalizerFailureStopsInstance(c *gc.C) {
+	stopped, _ := s.assertFinalizerFailure(c, false)
+	c.Assert(stopped, jc.DeepEquals, []instance.Id{"i-broken"})
+}
+
+func (s *BootstrapSuite) TestFinalizerFailureKeepBroken(c *gc.C) {
+	stopped, stderr := s.assertFinalizerFailure(c, true)
+	c.Assert(stopped, gc.HasLen, 0)
+	c.Assert(stderr, jc.Contains, "keeping instance i-broken for diagnosis\n - 10.0.0.1\n")
+}
+
 type neverRefreshes struct {
 }
 
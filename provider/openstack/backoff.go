@@ -0,0 +1,59 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"time"
+)
+
+// backoffStrategy describes a series of attempts whose delays double
+// from Initial up to Max, stopping once the next attempt would start
+// after Total has elapsed.
+type backoffStrategy struct {
+	Initial time.Duration
+	Max     time.Duration
+	Total   time.Duration
+}
+
+// These are patched in tests to avoid sleeping.
+var (
+	backoffNow   = time.Now
+	backoffSleep = time.Sleep
+)
+
+// backoffAttempt is a single run of a backoffStrategy.
+type backoffAttempt struct {
+	strategy backoffStrategy
+	end      time.Time
+	delay    time.Duration
+	started  bool
+}
+
+// Start begins a new series of attempts.
+func (s backoffStrategy) Start() *backoffAttempt {
+	return &backoffAttempt{
+		strategy: s,
+		end:      backoffNow().Add(s.Total),
+		delay:    s.Initial,
+	}
+}
+
+// Next waits until it is time for the next attempt and returns true,
+// or returns false if the next attempt would start after the total
+// time has elapsed. The first call always returns true immediately.
+func (a *backoffAttempt) Next() bool {
+	if !a.started {
+		a.started = true
+		return true
+	}
+	if backoffNow().Add(a.delay).After(a.end) {
+		return false
+	}
+	backoffSleep(a.delay)
+	a.delay *= 2
+	if a.delay > a.strategy.Max {
+		a.delay = a.strategy.Max
+	}
+	return true
+}
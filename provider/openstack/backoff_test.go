@@ -0,0 +1,68 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/testing"
+)
+
+type backoffSuite struct {
+	testing.BaseSuite
+	now    time.Time
+	sleeps []time.Duration
+}
+
+var _ = gc.Suite(&backoffSuite{})
+
+func (s *backoffSuite) SetUpTest(c *gc.C) {
+	s.BaseSuite.SetUpTest(c)
+	s.now = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.sleeps = nil
+	s.PatchValue(&backoffNow, func() time.Time { return s.now })
+	s.PatchValue(&backoffSleep, func(d time.Duration) {
+		s.sleeps = append(s.sleeps, d)
+		s.now = s.now.Add(d)
+	})
+}
+
+func (s *backoffSuite) TestDelaysDoubleUpToMax(c *gc.C) {
+	strategy := backoffStrategy{
+		Initial: 100 * time.Millisecond,
+		Max:     time.Second,
+		Total:   time.Hour,
+	}
+	a := strategy.Start()
+	for i := 0; i < 7; i++ {
+		c.Assert(a.Next(), gc.Equals, true)
+	}
+	c.Assert(s.sleeps, gc.DeepEquals, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	})
+}
+
+func (s *backoffSuite) TestStopsWithinTotal(c *gc.C) {
+	strategy := backoffStrategy{
+		Initial: time.Second,
+		Max:     4 * time.Second,
+		Total:   10 * time.Second,
+	}
+	start := s.now
+	attempts := 0
+	for a := strategy.Start(); a.Next(); {
+		attempts++
+	}
+	// Attempts are made at 0s, 1s, 3s and 7s; one at 11s would start
+	// after the total has elapsed.
+	c.Assert(attempts, gc.Equals, 4)
+	c.Assert(s.now.Sub(start), gc.Equals, 7*time.Second)
+}
@@ -5,13 +5,17 @@ package openstack
 
 import (
 	"math"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
 
 	"github.com/juju/errors"
+	"github.com/juju/schema"
 	"github.com/juju/utils"
 	"gopkg.in/goose.v1/cinder"
+	"gopkg.in/goose.v1/client"
+	goosehttp "gopkg.in/goose.v1/http"
 	"gopkg.in/goose.v1/nova"
 
 	"github.com/juju/juju/environs/config"
@@ -22,6 +26,17 @@ import (
 
 const (
 	CinderProviderType = storage.ProviderType("cinder")
+
+	// Config attributes
+
+	// cinderVolumeType is the name of the Cinder volume type to use
+	// when creating volumes. If empty, the cloud's default is used.
+	cinderVolumeType = "volume-type"
+
+	// cinderEncrypted specifies whether volumes must be encrypted.
+	// If true, volume-type must name an encrypted volume type.
+	cinderEncrypted = "encrypted"
+
 	// autoAssignedMountPoint specifies the value to pass in when
 	// you'd like Cinder to automatically assign a mount point.
 	autoAssignedMountPoint = ""
@@ -38,11 +53,56 @@ type cinderProvider struct {
 
 var _ storage.Provider = (*cinderProvider)(nil)
 
+var cinderConfigFields = schema.Fields{
+	storage.Persistent: schema.Bool(),
+	cinderVolumeType:   schema.String(),
+	cinderEncrypted:    schema.Bool(),
+}
+
+var cinderConfigChecker = schema.FieldMap(
+	cinderConfigFields,
+	schema.Defaults{
+		storage.Persistent: false,
+		cinderVolumeType:   "",
+		cinderEncrypted:    false,
+	},
+)
+
+type cinderConfig struct {
+	volumeType string
+	encrypted  bool
+}
+
+func newCinderConfig(attrs map[string]interface{}) (*cinderConfig, error) {
+	out, err := cinderConfigChecker.Coerce(attrs, nil)
+	if err != nil {
+		return nil, errors.Annotate(err, "validating Cinder storage config")
+	}
+	coerced := out.(map[string]interface{})
+	cinderConfig := &cinderConfig{
+		volumeType: coerced[cinderVolumeType].(string),
+		encrypted:  coerced[cinderEncrypted].(bool),
+	}
+	if cinderConfig.encrypted && cinderConfig.volumeType == "" {
+		return nil, errors.Errorf("encrypted volumes require %s to be set", cinderVolumeType)
+	}
+	return cinderConfig, nil
+}
+
+// cinderAttempt is the default strategy used to wait for Cinder volume
+// operations; cinder-timeout and cinder-poll-interval override it.
 var cinderAttempt = utils.AttemptStrategy{
 	Total: 1 * time.Minute,
 	Delay: 5 * time.Second,
 }
 
+// cinderAttemptStrategy returns the strategy used to wait for Cinder
+// volume operations in an environment with the given configuration.
+func cinderAttemptStrategy(cfg *config.Config) (utils.AttemptStrategy, error) {
+	ecfg := &environConfig{cfg, cfg.UnknownAttrs()}
+	return ecfg.cinderAttemptStrategy()
+}
+
 // VolumeSource implements storage.Provider.
 func (p *cinderProvider) VolumeSource(environConfig *config.Config, providerConfig *storage.Config) (storage.VolumeSource, error) {
 	if err := p.ValidateConfig(providerConfig); err != nil {
@@ -56,10 +116,16 @@ func (p *cinderProvider) VolumeSource(environConfig *config.Config, providerConf
 	if !ok {
 		return nil, errors.NotFoundf("environment UUID")
 	}
+	attempt, err := cinderAttemptStrategy(environConfig)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	source := &cinderVolumeSource{
-		storageAdapter: storageAdapter,
-		envName:        environConfig.Name(),
-		envUUID:        uuid,
+		storageAdapter:    storageAdapter,
+		envName:           environConfig.Name(),
+		envUUID:           uuid,
+		metadataKeyPrefix: metadataKeyPrefix(environConfig),
+		attempt:           attempt,
 	}
 	return source, nil
 }
@@ -87,7 +153,8 @@ func (s *cinderProvider) Scope() storage.Scope {
 func (p *cinderProvider) ValidateConfig(cfg *storage.Config) error {
 	// TODO(axw) 2015-05-01 #1450737
 	// Reject attempts to create non-persistent volumes.
-	return nil
+	_, err := newCinderConfig(cfg.Attrs())
+	return errors.Trace(err)
 }
 
 // Dynamic implements storage.Provider.
@@ -96,9 +163,11 @@ func (p *cinderProvider) Dynamic() bool {
 }
 
 type cinderVolumeSource struct {
-	storageAdapter openstackStorage
-	envName        string // non unique, informational only
-	envUUID        string
+	storageAdapter    openstackStorage
+	envName           string // non unique, informational only
+	envUUID           string
+	metadataKeyPrefix string
+	attempt           utils.AttemptStrategy
 }
 
 var _ storage.VolumeSource = (*cinderVolumeSource)(nil)
@@ -118,9 +187,24 @@ func (s *cinderVolumeSource) CreateVolumes(args []storage.VolumeParams) ([]stora
 }
 
 func (s *cinderVolumeSource) createVolume(arg storage.VolumeParams) (*storage.Volume, error) {
+	cinderConfig, err := newCinderConfig(arg.Attributes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cinderConfig.encrypted {
+		// Cinder silently creates unencrypted volumes from a
+		// volume type without encryption, so check it first.
+		encrypted, err := s.storageAdapter.VolumeTypeEncrypted(cinderConfig.volumeType)
+		if err != nil {
+			return nil, errors.Annotatef(err, "checking volume type %q", cinderConfig.volumeType)
+		}
+		if !encrypted {
+			return nil, errors.Errorf("volume type %q is not encrypted", cinderConfig.volumeType)
+		}
+	}
 	var metadata interface{}
 	if len(arg.ResourceTags) > 0 {
-		metadata = arg.ResourceTags
+		metadata = prefixedMetadata(s.metadataKeyPrefix, arg.ResourceTags)
 	}
 	cinderVolume, err := s.storageAdapter.CreateVolume(cinder.CreateVolumeVolumeParams{
 		// The Cinder documentation incorrectly states the
@@ -130,6 +214,7 @@ func (s *cinderVolumeSource) createVolume(arg storage.VolumeParams) (*storage.Vo
 		// TODO(axw) use the AZ of the initially attached machine.
 		AvailabilityZone: "",
 		Metadata:         metadata,
+		VolumeType:       cinderConfig.volumeType,
 	})
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -160,7 +245,7 @@ func (s *cinderVolumeSource) ListVolumes() ([]string, error) {
 	}
 	volumeIds := make([]string, 0, len(cinderVolumes))
 	for _, volume := range cinderVolumes {
-		envUUID, ok := volume.Metadata[tags.JujuEnv]
+		envUUID, ok := volume.Metadata[prefixedMetadataKey(s.metadataKeyPrefix, tags.JujuEnv)]
 		if !ok || envUUID != s.envUUID {
 			continue
 		}
@@ -316,7 +401,7 @@ func (s *cinderVolumeSource) waitVolume(
 	volumeId string,
 	pred func(*cinder.Volume) (bool, error),
 ) (*cinder.Volume, error) {
-	for a := cinderAttempt.Start(); a.Next(); {
+	for a := s.attempt.Start(); a.Next(); {
 		volume, err := s.storageAdapter.GetVolume(volumeId)
 		if err != nil {
 			return nil, errors.Annotate(err, "getting volume")
@@ -394,6 +479,7 @@ type openstackStorage interface {
 	AttachVolume(serverId, volumeId, mountPoint string) (*nova.VolumeAttachment, error)
 	DetachVolume(serverId, attachmentId string) error
 	ListVolumeAttachments(serverId string) ([]nova.VolumeAttachment, error)
+	VolumeTypeEncrypted(volumeType string) (bool, error)
 }
 
 func newOpenstackStorageAdapter(environConfig *config.Config) (openstackStorage, error) {
@@ -418,12 +504,14 @@ func newOpenstackStorageAdapter(environConfig *config.Config) (openstackStorage,
 	return &openstackStorageAdapter{
 		cinderClient{cinder.Basic(endpointUrl, authClient.TenantId(), authClient.Token)},
 		novaClient{nova.New(authClient)},
+		authClient,
 	}, nil
 }
 
 type openstackStorageAdapter struct {
 	cinderClient
 	novaClient
+	client client.Client
 }
 
 type cinderClient struct {
@@ -460,3 +548,43 @@ func (ga *openstackStorageAdapter) GetVolume(volumeId string) (*cinder.Volume, e
 	}
 	return &resp.Volume, nil
 }
+
+// VolumeTypeEncrypted is part of the openstackStorage interface.
+func (ga *openstackStorageAdapter) VolumeTypeEncrypted(volumeType string) (bool, error) {
+	var types struct {
+		VolumeTypes []struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"volume_types"`
+	}
+	err := ga.client.SendRequest(client.GET, "volume", "types", &goosehttp.RequestData{
+		RespValue:      &types,
+		ExpectedStatus: []int{http.StatusOK},
+	})
+	if err != nil {
+		return false, errors.Annotate(err, "listing volume types")
+	}
+	var volumeTypeId string
+	for _, t := range types.VolumeTypes {
+		if t.Name == volumeType || t.Id == volumeType {
+			volumeTypeId = t.Id
+			break
+		}
+	}
+	if volumeTypeId == "" {
+		return false, errors.NotFoundf("volume type %q", volumeType)
+	}
+	// A volume type without encryption has an empty
+	// encryption specification.
+	var encryption struct {
+		Provider string `json:"provider"`
+	}
+	err = ga.client.SendRequest(client.GET, "volume", "types/"+volumeTypeId+"/encryption", &goosehttp.RequestData{
+		RespValue:      &encryption,
+		ExpectedStatus: []int{http.StatusOK},
+	})
+	if err != nil {
+		return false, errors.Annotate(err, "getting volume type encryption")
+	}
+	return encryption.Provider != "", nil
+}
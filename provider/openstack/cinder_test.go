@@ -207,6 +207,145 @@ func (s *cinderVolumeSourceSuite) TestResourceTags(c *gc.C) {
 	c.Assert(created, jc.IsTrue)
 }
 
+func (s *cinderVolumeSourceSuite) TestCreateVolumeEncrypted(c *gc.C) {
+	var created bool
+	mockAdapter := &mockAdapter{
+		volumeTypeEncrypted: func(volumeType string) (bool, error) {
+			c.Check(volumeType, gc.Equals, "luks")
+			return true, nil
+		},
+		createVolume: func(args cinder.CreateVolumeVolumeParams) (*cinder.Volume, error) {
+			created = true
+			c.Assert(args, jc.DeepEquals, cinder.CreateVolumeVolumeParams{
+				Size:       1,
+				Name:       "juju-testenv-volume-123",
+				VolumeType: "luks",
+			})
+			return &cinder.Volume{ID: mockVolId}, nil
+		},
+		getVolume: func(volumeId string) (*cinder.Volume, error) {
+			return &cinder.Volume{
+				ID:     volumeId,
+				Size:   1,
+				Status: "available",
+			}, nil
+		},
+	}
+
+	volSource := openstack.NewCinderVolumeSource(mockAdapter)
+	results, err := volSource.CreateVolumes([]storage.VolumeParams{{
+		Provider: openstack.CinderProviderType,
+		Tag:      mockVolumeTag,
+		Size:     1024,
+		Attributes: map[string]interface{}{
+			"volume-type": "luks",
+			"encrypted":   true,
+		},
+	}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(results, gc.HasLen, 1)
+	c.Assert(results[0].Error, jc.ErrorIsNil)
+	c.Assert(created, jc.IsTrue)
+	mockAdapter.CheckCallNames(c, "VolumeTypeEncrypted", "CreateVolume", "GetVolume")
+}
+
+func (s *cinderVolumeSourceSuite) TestCreateVolumeNotEncrypted(c *gc.C) {
+	mockAdapter := &mockAdapter{
+		volumeTypeEncrypted: func(volumeType string) (bool, error) {
+			return false, nil
+		},
+	}
+
+	volSource := openstack.NewCinderVolumeSource(mockAdapter)
+	results, err := volSource.CreateVolumes([]storage.VolumeParams{{
+		Provider: openstack.CinderProviderType,
+		Tag:      mockVolumeTag,
+		Size:     1024,
+		Attributes: map[string]interface{}{
+			"volume-type": "standard",
+			"encrypted":   true,
+		},
+	}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(results, gc.HasLen, 1)
+	c.Assert(results[0].Error, gc.ErrorMatches, `volume type "standard" is not encrypted`)
+	mockAdapter.CheckCallNames(c, "VolumeTypeEncrypted")
+}
+
+// slowCreateAdapter returns a storage adapter whose volumes take the
+// given number of polls to be created.
+func slowCreateAdapter(polls int) *mockAdapter {
+	var getVolumeCalls int
+	return &mockAdapter{
+		createVolume: func(args cinder.CreateVolumeVolumeParams) (*cinder.Volume, error) {
+			return &cinder.Volume{ID: mockVolId}, nil
+		},
+		getVolume: func(volumeId string) (*cinder.Volume, error) {
+			getVolumeCalls++
+			status := ""
+			if getVolumeCalls > polls {
+				status = "available"
+			}
+			return &cinder.Volume{ID: volumeId, Status: status}, nil
+		},
+	}
+}
+
+func (s *cinderVolumeSourceSuite) TestCreateVolumeHonoursCinderTimeout(c *gc.C) {
+	s.PatchValue(openstack.CinderAttempt, utils.AttemptStrategy{
+		Total: 10 * time.Millisecond,
+		Delay: time.Millisecond,
+	})
+	params := []storage.VolumeParams{{
+		Provider: openstack.CinderProviderType,
+		Tag:      mockVolumeTag,
+		Size:     1024,
+	}}
+	providerConfig, err := storage.NewConfig("cinder", openstack.CinderProviderType, map[string]interface{}{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	// With the default timeout, the slow volume is not created in time.
+	volSource, err := openstack.NewCinderProvider(slowCreateAdapter(100)).VolumeSource(
+		testing.EnvironConfig(c), providerConfig)
+	c.Assert(err, jc.ErrorIsNil)
+	results, err := volSource.CreateVolumes(params)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(results, gc.HasLen, 1)
+	c.Assert(results[0].Error, gc.ErrorMatches, "waiting for volume to be provisioned: timed out")
+
+	volSource, err = openstack.NewCinderProvider(slowCreateAdapter(100)).VolumeSource(
+		testing.CustomEnvironConfig(c, testing.Attrs{
+			"uuid":                 testing.EnvironmentTag.Id(),
+			"cinder-timeout":       "1m",
+			"cinder-poll-interval": "1ms",
+		}), providerConfig)
+	c.Assert(err, jc.ErrorIsNil)
+	results, err = volSource.CreateVolumes(params)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(results, gc.HasLen, 1)
+	c.Assert(results[0].Error, jc.ErrorIsNil)
+}
+
+func (s *cinderVolumeSourceSuite) TestVolumeSourceInvalidCinderTimeout(c *gc.C) {
+	providerConfig, err := storage.NewConfig("cinder", openstack.CinderProviderType, map[string]interface{}{})
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = openstack.NewCinderProvider(&mockAdapter{}).VolumeSource(
+		testing.CustomEnvironConfig(c, testing.Attrs{
+			"uuid":           testing.EnvironmentTag.Id(),
+			"cinder-timeout": "soon",
+		}), providerConfig)
+	c.Assert(err, gc.ErrorMatches, `invalid cinder-timeout "soon": .*`)
+}
+
+func (s *cinderVolumeSourceSuite) TestValidateConfigEncryptedWithoutVolumeType(c *gc.C) {
+	cfg, err := storage.NewConfig("cinder", openstack.CinderProviderType, map[string]interface{}{
+		"encrypted": true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = openstack.NewCinderProvider(&mockAdapter{}).ValidateConfig(cfg)
+	c.Assert(err, gc.ErrorMatches, "encrypted volumes require volume-type to be set")
+}
+
 func (s *cinderVolumeSourceSuite) TestListVolumes(c *gc.C) {
 	mockAdapter := &mockAdapter{
 		getVolumesDetail: func() ([]cinder.Volume, error) {
@@ -231,6 +370,60 @@ func (s *cinderVolumeSourceSuite) TestListVolumes(c *gc.C) {
 	c.Check(volumeIds, jc.DeepEquals, []string{"volume-3"})
 }
 
+func (s *cinderVolumeSourceSuite) TestListVolumesMetadataKeyPrefix(c *gc.C) {
+	mockAdapter := &mockAdapter{
+		getVolumesDetail: func() ([]cinder.Volume, error) {
+			return []cinder.Volume{{
+				ID: "volume-1",
+				Metadata: map[string]string{
+					tags.JujuEnv: testing.EnvironmentTag.Id(),
+				},
+			}, {
+				ID: "volume-2",
+				Metadata: map[string]string{
+					"acme-env-uuid": testing.EnvironmentTag.Id(),
+				},
+			}}, nil
+		},
+	}
+	volSource := openstack.NewCinderVolumeSourceWithPrefix(mockAdapter, "acme-")
+	volumeIds, err := volSource.ListVolumes()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(volumeIds, jc.DeepEquals, []string{"volume-2"})
+}
+
+func (s *cinderVolumeSourceSuite) TestCreateVolumeMetadataKeyPrefix(c *gc.C) {
+	mockAdapter := &mockAdapter{
+		createVolume: func(args cinder.CreateVolumeVolumeParams) (*cinder.Volume, error) {
+			c.Check(args.Metadata, jc.DeepEquals, map[string]string{
+				"acme-env-uuid": testing.EnvironmentTag.Id(),
+				"cost-center":   "1234",
+			})
+			return &cinder.Volume{ID: mockVolId}, nil
+		},
+		getVolume: func(volumeId string) (*cinder.Volume, error) {
+			return &cinder.Volume{
+				ID:     volumeId,
+				Size:   1,
+				Status: "available",
+			}, nil
+		},
+	}
+	volSource := openstack.NewCinderVolumeSourceWithPrefix(mockAdapter, "acme-")
+	results, err := volSource.CreateVolumes([]storage.VolumeParams{{
+		Provider: openstack.CinderProviderType,
+		Tag:      mockVolumeTag,
+		Size:     1024,
+		ResourceTags: map[string]string{
+			tags.JujuEnv:  testing.EnvironmentTag.Id(),
+			"cost-center": "1234",
+		},
+	}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(results, gc.HasLen, 1)
+	c.Assert(results[0].Error, jc.ErrorIsNil)
+}
+
 func (s *cinderVolumeSourceSuite) TestDescribeVolumes(c *gc.C) {
 	mockAdapter := &mockAdapter{
 		getVolumesDetail: func() ([]cinder.Volume, error) {
@@ -438,6 +631,7 @@ type mockAdapter struct {
 	volumeStatusNotifier  func(string, string, int, time.Duration) <-chan error
 	detachVolume          func(string, string) error
 	listVolumeAttachments func(string) ([]nova.VolumeAttachment, error)
+	volumeTypeEncrypted   func(string) (bool, error)
 }
 
 func (ma *mockAdapter) GetVolume(volumeId string) (*cinder.Volume, error) {
@@ -498,3 +692,11 @@ func (ma *mockAdapter) ListVolumeAttachments(serverId string) ([]nova.VolumeAtta
 	}
 	return nil, nil
 }
+
+func (ma *mockAdapter) VolumeTypeEncrypted(volumeType string) (bool, error) {
+	ma.MethodCall(ma, "VolumeTypeEncrypted", volumeType)
+	if ma.volumeTypeEncrypted != nil {
+		return ma.volumeTypeEncrypted(volumeType)
+	}
+	return false, nil
+}
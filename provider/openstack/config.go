@@ -6,12 +6,18 @@ package openstack
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/juju/schema"
+	"github.com/juju/utils"
 	"gopkg.in/goose.v1/identity"
 	"gopkg.in/juju/environschema.v1"
 
+	"github.com/juju/juju/constraints"
 	"github.com/juju/juju/environs/config"
+	"github.com/juju/juju/environs/tags"
 )
 
 var configSchema = environschema.Fields{
@@ -82,6 +88,105 @@ var configSchema = environschema.Fields{
 		Description: "The network label or UUID to bring machines up on when multiple networks exist.",
 		Type:        environschema.Tstring,
 	},
+	"security-groups": {
+		Description: "A comma-separated list of existing Openstack security groups that new machine instances are added to. Juju never creates or deletes these groups.",
+		Type:        environschema.Tstring,
+	},
+	"image-streams": {
+		Description: "A comma-separated list of image streams to search, in order of preference, when choosing an image for a new machine. The first stream with a matching image is used. If empty, only image-stream is searched.",
+		Type:        environschema.Tstring,
+	},
+	"image-exclude-pattern": {
+		Description: "A regular expression matching the ids of images that must never be used for new machines, such as test images that also appear in the image metadata. The whole id must match.",
+		Type:        environschema.Tstring,
+	},
+	"state-server-zones": {
+		Description: "A comma-separated list of the availability zones that state server instances are spread across. If empty, all available zones that are not drained are used.",
+		Type:        environschema.Tstring,
+	},
+	"instance-name-collision": {
+		Description: "What to do when a server already has the name chosen for a new machine instance, as happens when an earlier teardown was incomplete. With allow the instance is started with the same name, as Nova permits; with error it is not started; and with suffix a number is appended to the name to make it unique. Only error and suffix look for existing servers.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{"allow", "error", "suffix"},
+	},
+	"destroy-mode": {
+		Description: "What destroying the environment does to its instances. With terminate they are deleted, along with the environment's security groups. With stop they are shut off, their volumes detached and their floating IPs released, but the instances, the volumes and the security groups are kept, along with the environment's configuration, for example for a grace period; destroying the environment again, in either mode, then removes them.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{"terminate", "stop"},
+	},
+	"image-required-properties": {
+		Description: "Glance image properties, such as hardened=true, that an image must have to be used for new machines. Images without all of the properties set to the given values are skipped.",
+		Type:        environschema.Tattrs,
+	},
+	"drained-availability-zones": {
+		Description: "A comma-separated list of availability zones, such as those under maintenance, in which no new machine instances are started. Existing instances in the zones are left alone.",
+		Type:        environschema.Tstring,
+	},
+	"instance-name-template": {
+		Description: "A template for the names of machine instances. The placeholders {env} and {machine} are replaced by the environment name and machine id; {machine} is required. Characters other than letters, digits, '.', '_' and '-' are replaced by '-'. If empty, instances are named juju-<env>-machine-<id>. It cannot be changed once the environment is running.",
+		Type:        environschema.Tstring,
+	},
+	"floating-ip-concurrency": {
+		Description: "The maximum number of floating IP addresses allocated at once when use-floating-ip is true. Raising it speeds up starting many machines on clouds whose API rate limits allow it.",
+		Type:        environschema.Tint,
+	},
+	"instance-fqdn-template": {
+		Description: "A template for a fully qualified domain name set in the fqdn metadata of each new machine instance, named like the other Juju metadata using metadata-key-prefix, for use by external DNS tools. The placeholders {env} and {machine} are replaced by the environment name and machine id; {machine} is required. If empty, no FQDN is set.",
+		Type:        environschema.Tstring,
+	},
+	"list-servers-concurrency": {
+		Description: "The number of requests used at once to list the environment's machine instances. If it is more than 1, the listing is split by the last digit of the machine id into ten smaller requests, which helps on environments with very many machines. It has no effect when instance-name-template is set.",
+		Type:        environschema.Tint,
+	},
+	"reuse-floating-ips": {
+		Description: "Whether floating IP addresses already allocated to the tenant but not associated with an instance are used for new machines before any new address is allocated. Set it to false to always allocate a fresh address.",
+		Type:        environschema.Tbool,
+	},
+	"show-unhealthy-servers": {
+		Description: "Whether the environment's servers that are not running or stopped, such as those in the ERROR state, are listed as instances, so that they can be seen and cleaned up. Their status is marked as unhealthy.",
+		Type:        environschema.Tbool,
+	},
+	"default-constraints": {
+		Description: "Constraints, such as root-disk=20G, applied to every new machine instance in the environment. Constraints given explicitly for a machine take precedence. Conflicting constraints are dropped, so an explicit instance-type overrides a default mem.",
+		Type:        environschema.Tstring,
+	},
+	"retry-zones-on-no-valid-host": {
+		Description: `Whether a "No valid host" error when starting an instance causes the next availability zone to be tried. Set it to false on clouds where the error does not depend on the zone, so that starting the instance fails straight away.`,
+		Type:        environschema.Tbool,
+	},
+	"metadata-key-prefix": {
+		Description: "The prefix used, in place of juju-, for the keys of the Nova and Cinder metadata that Juju sets on instances and volumes. Change it to avoid collisions with other tools on a shared tenant. It cannot be changed once the environment is bootstrapped.",
+		Type:        environschema.Tstring,
+	},
+	"network-reachability-check": {
+		Description: "What to do at bootstrap when use-floating-ip is false and the instance would only have addresses on private networks, so it may not be reachable from the client. With warn a warning is logged, with error bootstrap fails, and with none no check is made.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{"warn", "error", "none"},
+	},
+	"instances-poll-delay": {
+		Description: "The delay, such as 200ms, before the first retry when instances just started or stopped are not yet listed by the cloud. Each retry doubles the delay up to instances-poll-max-delay. If empty, 200ms is used.",
+		Type:        environschema.Tstring,
+	},
+	"instances-poll-max-delay": {
+		Description: "The longest delay between retries when waiting for instances to be listed by the cloud. If empty, eight times instances-poll-delay is used.",
+		Type:        environschema.Tstring,
+	},
+	"instances-poll-total": {
+		Description: "How long to keep retrying, such as 15s, when waiting for instances to be listed by the cloud. Raise it on clouds that take longer to show changes. If empty, 15s is used.",
+		Type:        environschema.Tstring,
+	},
+	"cinder-timeout": {
+		Description: "How long to wait, such as 5m, for a Cinder volume to be created, attached or deleted. Raise it for slow storage backends. If empty, 1m is used.",
+		Type:        environschema.Tstring,
+	},
+	"cinder-poll-interval": {
+		Description: "The interval, such as 10s, at which Cinder volumes are polled while waiting for an operation to complete. If empty, 5s is used.",
+		Type:        environschema.Tstring,
+	},
+	"manage-security-groups": {
+		Description: "Whether Juju creates and manages its own security groups for machine instances. When false, instances are added only to the groups named in security-groups and firewall-mode must be none.",
+		Type:        environschema.Tbool,
+	},
 }
 
 var configFields = func() schema.Fields {
@@ -93,18 +198,79 @@ var configFields = func() schema.Fields {
 }()
 
 var configDefaults = schema.Defaults{
-	"username":             "",
-	"password":             "",
-	"tenant-name":          "",
-	"auth-url":             "",
-	"auth-mode":            string(AuthUserPass),
-	"access-key":           "",
-	"secret-key":           "",
-	"region":               "",
-	"control-bucket":       "",
-	"use-floating-ip":      false,
-	"use-default-secgroup": false,
-	"network":              "",
+	"username":                     "",
+	"password":                     "",
+	"tenant-name":                  "",
+	"auth-url":                     "",
+	"auth-mode":                    string(AuthUserPass),
+	"access-key":                   "",
+	"secret-key":                   "",
+	"region":                       "",
+	"control-bucket":               "",
+	"use-floating-ip":              false,
+	"use-default-secgroup":         false,
+	"network":                      "",
+	"security-groups":              "",
+	"manage-security-groups":       true,
+	"instance-name-template":       "",
+	"image-streams":                "",
+	"image-exclude-pattern":        "",
+	"drained-availability-zones":   "",
+	"image-required-properties":    schema.Omit,
+	"instance-name-collision":      "allow",
+	"destroy-mode":                 "terminate",
+	"state-server-zones":           "",
+	"floating-ip-concurrency":      1,
+	"list-servers-concurrency":     1,
+	"instance-fqdn-template":       "",
+	"retry-zones-on-no-valid-host": true,
+	"reuse-floating-ips":           true,
+	"default-constraints":          "",
+	"show-unhealthy-servers":       false,
+	"metadata-key-prefix":          tags.JujuTagPrefix,
+	"network-reachability-check":   "warn",
+	"instances-poll-delay":         "",
+	"instances-poll-max-delay":     "",
+	"instances-poll-total":         "",
+	"cinder-timeout":               "",
+	"cinder-poll-interval":         "",
+}
+
+// maxMetadataLength is the maximum length of the keys and values of
+// Nova server metadata items.
+const maxMetadataLength = 255
+
+// metadataKeyPrefix returns the metadata-key-prefix held in the given
+// environment configuration, or the default juju- prefix if it is not
+// set.
+func metadataKeyPrefix(cfg *config.Config) string {
+	if prefix, _ := cfg.UnknownAttrs()["metadata-key-prefix"].(string); prefix != "" {
+		return prefix
+	}
+	return tags.JujuTagPrefix
+}
+
+// prefixedMetadataKey returns the metadata key under which the given
+// Juju tag is stored, replacing its juju- prefix with the given prefix.
+// Keys of other tags are returned unchanged.
+func prefixedMetadataKey(prefix, key string) string {
+	if !strings.HasPrefix(key, tags.JujuTagPrefix) {
+		return key
+	}
+	return prefix + strings.TrimPrefix(key, tags.JujuTagPrefix)
+}
+
+// prefixedMetadata returns a copy of the given tags with the keys of
+// the Juju tags renamed by prefixedMetadataKey.
+func prefixedMetadata(prefix string, resourceTags map[string]string) map[string]string {
+	if resourceTags == nil {
+		return nil
+	}
+	metadata := make(map[string]string, len(resourceTags))
+	for k, v := range resourceTags {
+		metadata[prefixedMetadataKey(prefix, k)] = v
+	}
+	return metadata
 }
 
 type environConfig struct {
@@ -160,6 +326,188 @@ func (c *environConfig) network() string {
 	return c.attrs["network"].(string)
 }
 
+func (c *environConfig) securityGroups() []string {
+	var groups []string
+	for _, name := range strings.Split(c.attrs["security-groups"].(string), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// drainedAvailabilityZones returns the names of the availability zones
+// in which no new instances may be started.
+func (c *environConfig) drainedAvailabilityZones() []string {
+	var zones []string
+	for _, zone := range strings.Split(c.attrs["drained-availability-zones"].(string), ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// stateServerZones returns the names of the availability zones that
+// state servers are restricted to, if any.
+func (c *environConfig) stateServerZones() []string {
+	var zones []string
+	for _, zone := range strings.Split(c.attrs["state-server-zones"].(string), ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// imageStreams returns the image streams to search for images, in
+// order of preference.
+func (c *environConfig) imageStreams() []string {
+	var streams []string
+	for _, stream := range strings.Split(c.attrs["image-streams"].(string), ",") {
+		if stream = strings.TrimSpace(stream); stream != "" {
+			streams = append(streams, stream)
+		}
+	}
+	if len(streams) == 0 {
+		streams = []string{c.ImageStream()}
+	}
+	return streams
+}
+
+// imageExcludePattern returns the expression matching the ids of
+// images that must not be used, or nil if no images are excluded.
+func (c *environConfig) imageExcludePattern() (*regexp.Regexp, error) {
+	pattern := c.attrs["image-exclude-pattern"].(string)
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid image-exclude-pattern %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// imageRequiredProperties returns the Glance properties, and their
+// values, that images must have to be used.
+func (c *environConfig) imageRequiredProperties() map[string]string {
+	properties, _ := c.attrs["image-required-properties"].(map[string]string)
+	return properties
+}
+
+func (c *environConfig) instanceNameTemplate() string {
+	return c.attrs["instance-name-template"].(string)
+}
+
+func (c *environConfig) instanceFQDNTemplate() string {
+	return c.attrs["instance-fqdn-template"].(string)
+}
+
+func (c *environConfig) manageSecurityGroups() bool {
+	return c.attrs["manage-security-groups"].(bool)
+}
+
+func (c *environConfig) floatingIPConcurrency() int {
+	return c.attrs["floating-ip-concurrency"].(int)
+}
+
+func (c *environConfig) listServersConcurrency() int {
+	return c.attrs["list-servers-concurrency"].(int)
+}
+
+func (c *environConfig) metadataKeyPrefix() string {
+	return c.attrs["metadata-key-prefix"].(string)
+}
+
+func (c *environConfig) networkReachabilityCheck() string {
+	return c.attrs["network-reachability-check"].(string)
+}
+
+func (c *environConfig) instanceNameCollision() string {
+	return c.attrs["instance-name-collision"].(string)
+}
+
+func (c *environConfig) destroyMode() string {
+	return c.attrs["destroy-mode"].(string)
+}
+
+func (c *environConfig) retryZonesOnNoValidHost() bool {
+	return c.attrs["retry-zones-on-no-valid-host"].(bool)
+}
+
+func (c *environConfig) reuseFloatingIPs() bool {
+	return c.attrs["reuse-floating-ips"].(bool)
+}
+
+func (c *environConfig) showUnhealthyServers() bool {
+	return c.attrs["show-unhealthy-servers"].(bool)
+}
+
+// defaultConstraints returns the constraints applied to every new
+// machine instance unless overridden.
+func (c *environConfig) defaultConstraints() (constraints.Value, error) {
+	s := c.attrs["default-constraints"].(string)
+	cons, err := constraints.Parse(s)
+	if err != nil {
+		return constraints.Value{}, fmt.Errorf("invalid default-constraints %q: %v", s, err)
+	}
+	return cons, nil
+}
+
+// duration returns the duration held in the named attribute, or
+// the given default if it is empty or not set.
+func (c *environConfig) duration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value, _ := c.attrs[key].(string)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %q", key, value)
+	}
+	return d, nil
+}
+
+// instancesPollStrategy returns the strategy used to poll for
+// instances that are not yet listed by the cloud. Unset attributes
+// take their defaults from shortAttempt.
+func (c *environConfig) instancesPollStrategy() (backoffStrategy, error) {
+	var s backoffStrategy
+	var err error
+	if s.Initial, err = c.duration("instances-poll-delay", shortAttempt.Delay); err != nil {
+		return s, err
+	}
+	if s.Max, err = c.duration("instances-poll-max-delay", 8*s.Initial); err != nil {
+		return s, err
+	}
+	if s.Total, err = c.duration("instances-poll-total", shortAttempt.Total); err != nil {
+		return s, err
+	}
+	if s.Max < s.Initial {
+		return s, fmt.Errorf("instances-poll-max-delay %v is less than instances-poll-delay %v", s.Max, s.Initial)
+	}
+	return s, nil
+}
+
+// cinderAttemptStrategy returns the strategy used to wait for Cinder
+// volume operations. Unset attributes take their defaults from
+// cinderAttempt.
+func (c *environConfig) cinderAttemptStrategy() (utils.AttemptStrategy, error) {
+	s := cinderAttempt
+	var err error
+	if s.Total, err = c.duration("cinder-timeout", cinderAttempt.Total); err != nil {
+		return s, err
+	}
+	if s.Delay, err = c.duration("cinder-poll-interval", cinderAttempt.Delay); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
 func (p environProvider) newConfig(cfg *config.Config) (*environConfig, error) {
 	valid, err := p.Validate(cfg, nil)
 	if err != nil {
@@ -168,6 +516,16 @@ func (p environProvider) newConfig(cfg *config.Config) (*environConfig, error) {
 	return &environConfig{valid, valid.UnknownAttrs()}, nil
 }
 
+// credentialsMissing reports whether any of the account attributes
+// used by the authentication mode are unset.
+func (c *environConfig) credentialsMissing() bool {
+	user, secret := c.username(), c.password()
+	if c.authMode() == AuthKeyPair {
+		user, secret = c.accessKey(), c.secretKey()
+	}
+	return user == "" || secret == "" || c.authURL() == "" || c.tenantName() == "" || c.region() == ""
+}
+
 type AuthMode string
 
 const (
@@ -196,18 +554,9 @@ func (p environProvider) Validate(cfg, old *config.Config) (valid *config.Config
 		return nil, err
 	}
 
-	// Add Openstack specific defaults.
-	providerDefaults := make(map[string]interface{})
-
-	// Storage.
-	if _, ok := cfg.StorageDefaultBlockSource(); !ok {
-		providerDefaults[config.StorageDefaultBlockSourceKey] = CinderProviderType
-	}
-	if len(providerDefaults) > 0 {
-		if cfg, err = cfg.Apply(providerDefaults); err != nil {
-			return nil, err
-		}
-	}
+	// The default block storage source is not set here, as cinder
+	// is only a sensible default if the cloud has a volume endpoint.
+	// See setDefaultBlockSource.
 
 	ecfg := &environConfig{cfg, validated}
 
@@ -217,7 +566,88 @@ func (p environProvider) Validate(cfg, old *config.Config) (valid *config.Config
 			return nil, fmt.Errorf("invalid auth-url value %q", ecfg.authURL())
 		}
 	}
-	cred := identity.CredentialsFromEnv()
+	// Resource tags are applied to servers as Nova metadata, so
+	// they must fit within its limits.
+	if resourceTags, ok := cfg.ResourceTags(); ok {
+		for k, v := range resourceTags {
+			if len(k) > maxMetadataLength {
+				return nil, fmt.Errorf("resource tag %q: key longer than %d characters", k, maxMetadataLength)
+			}
+			if len(v) > maxMetadataLength {
+				return nil, fmt.Errorf("resource tag %q: value longer than %d characters", k, maxMetadataLength)
+			}
+		}
+	}
+	if template := ecfg.instanceNameTemplate(); template != "" {
+		if !strings.Contains(template, "{machine}") {
+			return nil, fmt.Errorf("instance-name-template %q does not contain {machine}", template)
+		}
+		if _, err := renderInstanceName(template, cfg.Name(), "0"); err != nil {
+			return nil, err
+		}
+	}
+	// How the environment's servers are listed depends on whether
+	// the template is set, so it cannot change once they exist.
+	if old != nil {
+		oldTemplate, _ := old.UnknownAttrs()["instance-name-template"].(string)
+		if oldTemplate != ecfg.instanceNameTemplate() {
+			return nil, fmt.Errorf("cannot change instance-name-template from %q to %q", oldTemplate, ecfg.instanceNameTemplate())
+		}
+	}
+	if template := ecfg.instanceFQDNTemplate(); template != "" {
+		if !strings.Contains(template, "{machine}") {
+			return nil, fmt.Errorf("instance-fqdn-template %q does not contain {machine}", template)
+		}
+		if _, err := renderInstanceFQDN(template, cfg.Name(), "0"); err != nil {
+			return nil, err
+		}
+	}
+	if ecfg.metadataKeyPrefix() == "" {
+		return nil, fmt.Errorf("metadata-key-prefix must not be empty")
+	}
+	if old != nil && metadataKeyPrefix(old) != ecfg.metadataKeyPrefix() {
+		return nil, fmt.Errorf("cannot change metadata-key-prefix from %q to %q", metadataKeyPrefix(old), ecfg.metadataKeyPrefix())
+	}
+	if ecfg.floatingIPConcurrency() < 1 {
+		return nil, fmt.Errorf("floating-ip-concurrency must be at least 1, got %d", ecfg.floatingIPConcurrency())
+	}
+	if ecfg.listServersConcurrency() < 1 {
+		return nil, fmt.Errorf("list-servers-concurrency must be at least 1, got %d", ecfg.listServersConcurrency())
+	}
+	if _, err := ecfg.imageExcludePattern(); err != nil {
+		return nil, err
+	}
+	if _, err := ecfg.instancesPollStrategy(); err != nil {
+		return nil, err
+	}
+	if _, err := ecfg.cinderAttemptStrategy(); err != nil {
+		return nil, err
+	}
+	if _, err := ecfg.defaultConstraints(); err != nil {
+		return nil, err
+	}
+	for k := range ecfg.imageRequiredProperties() {
+		if k == "" {
+			return nil, fmt.Errorf("image-required-properties: empty property name")
+		}
+	}
+	if !ecfg.manageSecurityGroups() {
+		if len(ecfg.securityGroups()) == 0 {
+			return nil, fmt.Errorf("security-groups must be set when manage-security-groups is false")
+		}
+		if ecfg.FirewallMode() != config.FwNone {
+			return nil, fmt.Errorf("firewall-mode must be %q when manage-security-groups is false", config.FwNone)
+		}
+	}
+	// The defaults are only looked for when needed, so that a
+	// configuration holding every credential does not depend on the
+	// environment.
+	cred := &identity.Credentials{}
+	if ecfg.credentialsMissing() {
+		if cred, err = credentialsFromEnv(); err != nil {
+			return nil, err
+		}
+	}
 	format := "required environment variable not set for credentials attribute: %s"
 	switch ecfg.authMode() {
 	case AuthUserPass, AuthLegacy:
@@ -4,8 +4,12 @@
 package openstack
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/juju/errors"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
@@ -31,6 +35,8 @@ var envVars = map[string]string{
 	"NOVA_USERNAME":         "",
 	"OS_ACCESS_KEY":         "",
 	"OS_AUTH_URL":           "",
+	"OS_CLIENT_CONFIG_FILE": "",
+	"OS_CLOUD":              "",
 	"OS_PASSWORD":           "",
 	"OS_REGION_NAME":        "",
 	"OS_SECRET_KEY":         "",
@@ -166,13 +172,9 @@ func (t configTest) check(c *gc.C) {
 		c.Check(found, jc.IsTrue)
 		c.Check(actual, gc.Equals, expect)
 	}
-	expectedStorage := "cinder"
-	if t.blockStorageSource != "" {
-		expectedStorage = t.blockStorageSource
-	}
 	storage, ok := ecfg.StorageDefaultBlockSource()
-	c.Assert(ok, jc.IsTrue)
-	c.Assert(storage, gc.Equals, expectedStorage)
+	c.Assert(ok, gc.Equals, t.blockStorageSource != "")
+	c.Assert(storage, gc.Equals, t.blockStorageSource)
 }
 
 func (s *ConfigSuite) SetUpTest(c *gc.C) {
@@ -383,6 +385,271 @@ var configTests = []configTest{
 			"use-default-secgroup": true,
 		},
 		useDefaultSecurityGroup: true,
+	}, {
+		summary: "existing security groups",
+		config: attrs{
+			"security-groups": "web, ssh",
+		},
+		expect: attrs{
+			"security-groups":        "web, ssh",
+			"manage-security-groups": true,
+		},
+	}, {
+		summary: "unmanaged security groups",
+		config: attrs{
+			"security-groups":        "web",
+			"manage-security-groups": false,
+			"firewall-mode":          "none",
+		},
+		firewallMode: config.FwNone,
+		expect: attrs{
+			"manage-security-groups": false,
+		},
+	}, {
+		summary: "unmanaged security groups without existing groups",
+		config: attrs{
+			"manage-security-groups": false,
+			"firewall-mode":          "none",
+		},
+		err: "security-groups must be set when manage-security-groups is false",
+	}, {
+		summary: "unmanaged security groups with firewalling",
+		config: attrs{
+			"security-groups":        "web",
+			"manage-security-groups": false,
+		},
+		err: `firewall-mode must be "none" when manage-security-groups is false`,
+	}, {
+		summary: "resource tags",
+		config: attrs{
+			"resource-tags": "cost-center=1234",
+		},
+	}, {
+		summary: "resource tag key too long",
+		config: attrs{
+			"resource-tags": strings.Repeat("k", 256) + "=v",
+		},
+		err: `resource tag "k+": key longer than 255 characters`,
+	}, {
+		summary: "resource tag value too long",
+		config: attrs{
+			"resource-tags": "cost-center=" + strings.Repeat("v", 256),
+		},
+		err: `resource tag "cost-center": value longer than 255 characters`,
+	}, {
+		summary: "instance name template",
+		config: attrs{
+			"instance-name-template": "{env}-node-{machine}",
+		},
+		expect: attrs{
+			"instance-name-template": "{env}-node-{machine}",
+		},
+	}, {
+		summary: "instance name template without machine",
+		config: attrs{
+			"instance-name-template": "{env}-node",
+		},
+		err: `instance-name-template "{env}-node" does not contain {machine}`,
+	}, {
+		summary: "cannot change instance name template",
+		config: attrs{
+			"instance-name-template": "{env}-node-{machine}",
+		},
+		change: attrs{
+			"instance-name-template": "",
+		},
+		err: `cannot change instance-name-template from "{env}-node-{machine}" to ""`,
+	}, {
+		summary: "cannot set instance name template",
+		config:  attrs{},
+		change: attrs{
+			"instance-name-template": "{env}-node-{machine}",
+		},
+		err: `cannot change instance-name-template from "" to "{env}-node-{machine}"`,
+	}, {
+		summary: "instance name template with unknown placeholder",
+		config: attrs{
+			"instance-name-template": "{owner}-{machine}",
+		},
+		err: `unknown placeholder {owner} in instance-name-template`,
+	}, {
+		summary: "instance name template too long",
+		config: attrs{
+			"instance-name-template": strings.Repeat("x", 256) + "{machine}",
+		},
+		err: `instance name "x+0" is longer than 255 characters`,
+	}, {
+		summary: "floating ip concurrency",
+		config: attrs{
+			"floating-ip-concurrency": 4,
+		},
+		expect: attrs{
+			"floating-ip-concurrency": 4,
+		},
+	}, {
+		summary: "floating ip concurrency must be positive",
+		config: attrs{
+			"floating-ip-concurrency": 0,
+		},
+		err: `floating-ip-concurrency must be at least 1, got 0`,
+	}, {
+		summary: "list servers concurrency",
+		config: attrs{
+			"list-servers-concurrency": 4,
+		},
+		expect: attrs{
+			"list-servers-concurrency": 4,
+		},
+	}, {
+		summary: "list servers concurrency must be positive",
+		config: attrs{
+			"list-servers-concurrency": 0,
+		},
+		err: `list-servers-concurrency must be at least 1, got 0`,
+	}, {
+		summary: "default constraints",
+		config: attrs{
+			"default-constraints": "root-disk=20G",
+		},
+		expect: attrs{
+			"default-constraints": "root-disk=20G",
+		},
+	}, {
+		summary: "invalid default constraints",
+		config: attrs{
+			"default-constraints": "root-disk=lots",
+		},
+		err: `invalid default-constraints "root-disk=lots": bad "root-disk" constraint: .*`,
+	}, {
+		summary: "instance fqdn template",
+		config: attrs{
+			"instance-fqdn-template": "m{machine}.{env}.example.com",
+		},
+		expect: attrs{
+			"instance-fqdn-template": "m{machine}.{env}.example.com",
+		},
+	}, {
+		summary: "instance fqdn template without machine",
+		config: attrs{
+			"instance-fqdn-template": "{env}.example.com",
+		},
+		err: `instance-fqdn-template "{env}.example.com" does not contain {machine}`,
+	}, {
+		summary: "instance fqdn template with unknown placeholder",
+		config: attrs{
+			"instance-fqdn-template": "{machine}.{region}.example.com",
+		},
+		err: `unknown placeholder {region} in instance-fqdn-template`,
+	}, {
+		summary: "instance fqdn template renders an invalid name",
+		config: attrs{
+			"instance-fqdn-template": "{machine}_x.example.com",
+		},
+		err: `instance FQDN "0_x.example.com" is not a valid domain name`,
+	}, {
+		summary: "default metadata key prefix",
+		config:  attrs{},
+		expect: attrs{
+			"metadata-key-prefix": "juju-",
+		},
+	}, {
+		summary: "custom metadata key prefix",
+		config: attrs{
+			"metadata-key-prefix": "acme-",
+		},
+		expect: attrs{
+			"metadata-key-prefix": "acme-",
+		},
+	}, {
+		summary: "empty metadata key prefix",
+		config: attrs{
+			"metadata-key-prefix": "",
+		},
+		err: `metadata-key-prefix must not be empty`,
+	}, {
+		summary: "cannot change metadata key prefix",
+		config: attrs{
+			"metadata-key-prefix": "acme-",
+		},
+		change: attrs{
+			"metadata-key-prefix": "other-",
+		},
+		err: `cannot change metadata-key-prefix from "acme-" to "other-"`,
+	}, {
+		summary: "retry zones on no valid host by default",
+		config:  attrs{},
+		expect: attrs{
+			"retry-zones-on-no-valid-host": true,
+		},
+	}, {
+		summary: "no zone retry on no valid host",
+		config: attrs{
+			"retry-zones-on-no-valid-host": false,
+		},
+		expect: attrs{
+			"retry-zones-on-no-valid-host": false,
+		},
+	}, {
+		summary: "instances poll settings",
+		config: attrs{
+			"instances-poll-delay":     "500ms",
+			"instances-poll-max-delay": "5s",
+			"instances-poll-total":     "1m",
+		},
+		expect: attrs{
+			"instances-poll-delay":     "500ms",
+			"instances-poll-max-delay": "5s",
+			"instances-poll-total":     "1m",
+		},
+	}, {
+		summary: "cinder timeout and poll interval",
+		config: attrs{
+			"cinder-timeout":       "10m",
+			"cinder-poll-interval": "10s",
+		},
+		expect: attrs{
+			"cinder-timeout":       "10m",
+			"cinder-poll-interval": "10s",
+		},
+	}, {
+		summary: "non-positive cinder-poll-interval",
+		config: attrs{
+			"cinder-poll-interval": "0s",
+		},
+		err: `cinder-poll-interval must be positive, got "0s"`,
+	}, {
+		summary: "invalid instances-poll-total",
+		config: attrs{
+			"instances-poll-total": "forever",
+		},
+		err: `invalid instances-poll-total "forever": .*`,
+	}, {
+		summary: "non-positive instances-poll-delay",
+		config: attrs{
+			"instances-poll-delay": "0s",
+		},
+		err: `instances-poll-delay must be positive, got "0s"`,
+	}, {
+		summary: "instances-poll-max-delay less than instances-poll-delay",
+		config: attrs{
+			"instances-poll-delay":     "2s",
+			"instances-poll-max-delay": "1s",
+		},
+		err: `instances-poll-max-delay 1s is less than instances-poll-delay 2s`,
+	}, {
+		summary: "image-exclude-pattern",
+		config: attrs{
+			"image-exclude-pattern": "test-.*",
+		},
+		expect: attrs{
+			"image-exclude-pattern": "test-.*",
+		},
+	}, {
+		summary: "invalid image-exclude-pattern",
+		config: attrs{
+			"image-exclude-pattern": "test-(",
+		},
+		err: `invalid image-exclude-pattern "test-\(": .*`,
 	}, {
 		summary: "admin-secret given",
 		config: attrs{
@@ -446,9 +713,8 @@ var configTests = []configTest{
 		},
 		network: "a-network-label",
 	}, {
-		summary:            "no default block storage specified",
-		config:             attrs{},
-		blockStorageSource: "cinder",
+		summary: "no default block storage specified",
+		config:  attrs{},
 	}, {
 		summary: "block storage specified",
 		config: attrs{
@@ -466,6 +732,36 @@ func (s *ConfigSuite) TestConfig(c *gc.C) {
 	}
 }
 
+func (s *ConfigSuite) TestImageRequiredProperties(c *gc.C) {
+	s.setupEnvCredentials()
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":                      "openstack",
+		"image-required-properties": "hardened=true os_distro=ubuntu",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+	valid, err := providerInstance.Validate(cfg, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	ecfg, err := providerInstance.newConfig(valid)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(ecfg.imageRequiredProperties(), jc.DeepEquals, map[string]string{
+		"hardened":  "true",
+		"os_distro": "ubuntu",
+	})
+}
+
+func (s *ConfigSuite) TestImageRequiredPropertiesInvalid(c *gc.C) {
+	s.setupEnvCredentials()
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":                      "openstack",
+		"image-required-properties": "hardened",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = providerInstance.Validate(cfg, nil)
+	c.Assert(err, gc.ErrorMatches, `.*image-required-properties.*`)
+}
+
 func (s *ConfigSuite) TestDeprecatedAttributesRemoved(c *gc.C) {
 	s.setupEnvCredentials()
 	attrs := testing.FakeConfig().Merge(testing.Attrs{
@@ -527,6 +823,7 @@ func (s *ConfigSuite) TestPrepareDoesNotTouchExistingControlBucket(c *gc.C) {
 
 func (s *ConfigSuite) TestPrepareSetsDefaultBlockSource(c *gc.C) {
 	s.setupEnvCredentials()
+	s.PatchValue(&hasVolumeEndpoint, func(*environ) bool { return true })
 	attrs := testing.FakeConfig().Merge(testing.Attrs{
 		"type": "openstack",
 	})
@@ -540,6 +837,70 @@ func (s *ConfigSuite) TestPrepareSetsDefaultBlockSource(c *gc.C) {
 	c.Assert(source, gc.Equals, "cinder")
 }
 
+func (s *ConfigSuite) TestPrepareNoVolumeEndpointLeavesBlockSourceUnset(c *gc.C) {
+	s.setupEnvCredentials()
+	s.PatchValue(&hasVolumeEndpoint, func(*environ) bool { return false })
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type": "openstack",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	env, err := providerInstance.PrepareForBootstrap(envtesting.BootstrapContext(c), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	_, ok := env.(*environ).ecfg().StorageDefaultBlockSource()
+	c.Assert(ok, jc.IsFalse)
+	c.Assert(c.GetTestLog(), jc.Contains, "not setting a default block storage source")
+}
+
+func (s *ConfigSuite) TestPrepareKeepsSpecifiedBlockSource(c *gc.C) {
+	s.setupEnvCredentials()
+	s.PatchValue(&hasVolumeEndpoint, func(*environ) bool { return false })
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":                         "openstack",
+		"storage-default-block-source": "loop",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	env, err := providerInstance.PrepareForBootstrap(envtesting.BootstrapContext(c), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	source, ok := env.(*environ).ecfg().StorageDefaultBlockSource()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(source, gc.Equals, "loop")
+}
+
+func (s *ConfigSuite) TestPrepareForCreateEnvironmentSetsDefaultBlockSource(c *gc.C) {
+	s.setupEnvCredentials()
+	s.PatchValue(&hasVolumeEndpoint, func(*environ) bool { return true })
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type": "openstack",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	cfg, err = providerInstance.PrepareForCreateEnvironment(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	source, ok := cfg.StorageDefaultBlockSource()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(source, gc.Equals, "cinder")
+}
+
+func (s *ConfigSuite) TestPrepareForCreateEnvironmentNoVolumeEndpoint(c *gc.C) {
+	s.setupEnvCredentials()
+	s.PatchValue(&hasVolumeEndpoint, func(*environ) bool { return false })
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type": "openstack",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	cfg, err = providerInstance.PrepareForCreateEnvironment(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	_, ok := cfg.StorageDefaultBlockSource()
+	c.Assert(ok, jc.IsFalse)
+}
+
 func (s *ConfigSuite) setupEnvCredentials() {
 	os.Setenv("OS_USERNAME", "user")
 	os.Setenv("OS_PASSWORD", "secret")
@@ -548,6 +909,177 @@ func (s *ConfigSuite) setupEnvCredentials() {
 	os.Setenv("OS_REGION_NAME", "region")
 }
 
+var sampleCloudsYAML = `
+clouds:
+  mycloud:
+    auth:
+      auth_url: https://keystone.example.com:5000/v2.0
+      username: fred
+      project_name: fredsproject
+    region_name: region-a
+  automation:
+    auth_type: v3applicationcredential
+    auth:
+      auth_url: https://keystone.example.com:5000/v3
+      application_credential_id: 21dced0fd20347869b93710d2b98aae0
+    region_name: region-a
+`
+
+var sampleSecureYAML = `
+clouds:
+  mycloud:
+    auth:
+      password: sekrit
+  automation:
+    auth:
+      application_credential_secret: alsosekrit
+`
+
+func (s *ConfigSuite) writeCloudsFiles(c *gc.C) string {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "clouds.yaml")
+	err := ioutil.WriteFile(path, []byte(sampleCloudsYAML), 0600)
+	c.Assert(err, jc.ErrorIsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "secure.yaml"), []byte(sampleSecureYAML), 0600)
+	c.Assert(err, jc.ErrorIsNil)
+	return path
+}
+
+func (s *ConfigSuite) TestCredentialsFromCloudsFile(c *gc.C) {
+	os.Setenv("OS_CLIENT_CONFIG_FILE", s.writeCloudsFiles(c))
+	os.Setenv("OS_CLOUD", "mycloud")
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":           "openstack",
+		"control-bucket": "x",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	e, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	ecfg := e.(*environ).ecfg()
+	c.Check(ecfg.authURL(), gc.Equals, "https://keystone.example.com:5000/v2.0")
+	c.Check(ecfg.username(), gc.Equals, "fred")
+	c.Check(ecfg.password(), gc.Equals, "sekrit")
+	c.Check(ecfg.tenantName(), gc.Equals, "fredsproject")
+	c.Check(ecfg.region(), gc.Equals, "region-a")
+}
+
+func (s *ConfigSuite) TestCredentialsFromCloudsFileApplicationCredential(c *gc.C) {
+	os.Setenv("OS_CLIENT_CONFIG_FILE", s.writeCloudsFiles(c))
+	os.Setenv("OS_CLOUD", "automation")
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":           "openstack",
+		"control-bucket": "x",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = environs.New(cfg)
+	c.Assert(err, gc.ErrorMatches, `reading credentials from ".*clouds.yaml": cloud "automation": application credentials not supported`)
+}
+
+func (s *ConfigSuite) TestCloudsFileNotReadWithCompleteCredentials(c *gc.C) {
+	// Neither an application credential entry nor an unparsable
+	// clouds file matters when the config needs no defaults.
+	os.Setenv("OS_CLIENT_CONFIG_FILE", s.writeCloudsFiles(c))
+	os.Setenv("OS_CLOUD", "automation")
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":           "openstack",
+		"control-bucket": "x",
+		"auth-url":       "http://auth",
+		"username":       "user",
+		"password":       "secret",
+		"tenant-name":    "sometenant",
+		"region":         "region",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+
+	path := filepath.Join(c.MkDir(), "clouds.yaml")
+	err = ioutil.WriteFile(path, []byte("clouds: [}"), 0600)
+	c.Assert(err, jc.ErrorIsNil)
+	os.Setenv("OS_CLIENT_CONFIG_FILE", path)
+	_, err = environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *ConfigSuite) TestCredentialsIgnoreMissingCloudsFile(c *gc.C) {
+	s.setupEnvCredentials()
+	os.Setenv("OS_CLIENT_CONFIG_FILE", filepath.Join(c.MkDir(), "missing.yaml"))
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":           "openstack",
+		"control-bucket": "x",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	e, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	ecfg := e.(*environ).ecfg()
+	c.Check(ecfg.authURL(), gc.Equals, "http://auth")
+	c.Check(ecfg.username(), gc.Equals, "user")
+	c.Check(ecfg.password(), gc.Equals, "secret")
+}
+
+func (s *ConfigSuite) TestCredentialsUnreadableCloudsFile(c *gc.C) {
+	s.setupEnvCredentials()
+	os.Setenv("OS_CLIENT_CONFIG_FILE", c.MkDir())
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":           "openstack",
+		"control-bucket": "x",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = environs.New(cfg)
+	c.Assert(err, gc.ErrorMatches, `reading credentials from ".*": cannot read ".*": .*`)
+}
+
+func (s *ConfigSuite) TestCredentialsMalformedCloudsFile(c *gc.C) {
+	s.setupEnvCredentials()
+	path := filepath.Join(c.MkDir(), "clouds.yaml")
+	err := ioutil.WriteFile(path, []byte("clouds: [}"), 0600)
+	c.Assert(err, jc.ErrorIsNil)
+	os.Setenv("OS_CLIENT_CONFIG_FILE", path)
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":           "openstack",
+		"control-bucket": "x",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = environs.New(cfg)
+	c.Assert(err, gc.ErrorMatches, `reading credentials from ".*clouds.yaml": cannot parse ".*clouds.yaml": .*`)
+}
+
+func (s *ConfigSuite) TestCredentialsMalformedSecureFile(c *gc.C) {
+	s.setupEnvCredentials()
+	path := s.writeCloudsFiles(c)
+	err := ioutil.WriteFile(filepath.Join(filepath.Dir(path), "secure.yaml"), []byte("clouds: [}"), 0600)
+	c.Assert(err, jc.ErrorIsNil)
+	os.Setenv("OS_CLIENT_CONFIG_FILE", path)
+	os.Setenv("OS_CLOUD", "mycloud")
+	attrs := testing.FakeConfig().Merge(testing.Attrs{
+		"type":           "openstack",
+		"control-bucket": "x",
+	})
+	cfg, err := config.New(config.NoDefaults, attrs)
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = environs.New(cfg)
+	c.Assert(err, gc.ErrorMatches, `reading credentials from ".*clouds.yaml": cannot parse ".*secure.yaml": .*`)
+}
+
+func (s *ConfigSuite) TestCredentialsFromCloudsFileUnknownCloud(c *gc.C) {
+	_, err := cloudsFileCredentials(s.writeCloudsFiles(c), "nosuchcloud")
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+	_, err = cloudsFileCredentials(s.writeCloudsFiles(c), "")
+	c.Assert(err, gc.ErrorMatches, "OS_CLOUD must be set to choose one of 2 clouds")
+}
+
 func (*ConfigSuite) TestSchema(c *gc.C) {
 	fields := providerInstance.Schema()
 	// Check that all the fields defined in environs/config
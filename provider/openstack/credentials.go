@@ -0,0 +1,181 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/identity"
+	goyaml "gopkg.in/yaml.v1"
+)
+
+const (
+	// cloudsFileEnvVar holds the path of a clouds.yaml file to read
+	// credentials from, as used by the OpenStack client tools.
+	cloudsFileEnvVar = "OS_CLIENT_CONFIG_FILE"
+
+	// cloudNameEnvVar holds the name of the cloud entry to use from
+	// the clouds.yaml file.
+	cloudNameEnvVar = "OS_CLOUD"
+
+	// secureFileName is the name of the file, alongside clouds.yaml,
+	// that may hold the secret parts of the cloud entries.
+	secureFileName = "secure.yaml"
+)
+
+// cloudsFile holds the parts of a clouds.yaml (or secure.yaml) file
+// that we use.
+type cloudsFile struct {
+	Clouds map[string]cloudsFileEntry `yaml:"clouds"`
+}
+
+type cloudsFileEntry struct {
+	AuthType   string         `yaml:"auth_type"`
+	Auth       cloudsFileAuth `yaml:"auth"`
+	RegionName string         `yaml:"region_name"`
+}
+
+type cloudsFileAuth struct {
+	AuthURL                     string `yaml:"auth_url"`
+	Username                    string `yaml:"username"`
+	Password                    string `yaml:"password"`
+	ProjectName                 string `yaml:"project_name"`
+	TenantName                  string `yaml:"tenant_name"`
+	ApplicationCredentialId     string `yaml:"application_credential_id"`
+	ApplicationCredentialSecret string `yaml:"application_credential_secret"`
+}
+
+// credentialsFromEnv returns the credentials used to fill in any
+// account attributes missing from the environment configuration.
+// If OS_CLIENT_CONFIG_FILE names a clouds.yaml file, the cloud entry
+// named by OS_CLOUD is read from it; any values it does not hold are
+// taken from the usual OpenStack environment variables. A clouds.yaml
+// file that is missing provides no values, but one that cannot be read
+// or parsed is an error.
+func credentialsFromEnv() (*identity.Credentials, error) {
+	cred := identity.CredentialsFromEnv()
+	path := os.Getenv(cloudsFileEnvVar)
+	if path == "" {
+		return cred, nil
+	}
+	fileCred, err := cloudsFileCredentials(path, os.Getenv(cloudNameEnvVar))
+	if err != nil {
+		return nil, errors.Annotatef(err, "reading credentials from %q", path)
+	}
+	if fileCred == nil {
+		return cred, nil
+	}
+	for _, f := range []struct {
+		from string
+		to   *string
+	}{
+		{fileCred.URL, &cred.URL},
+		{fileCred.User, &cred.User},
+		{fileCred.Secrets, &cred.Secrets},
+		{fileCred.TenantName, &cred.TenantName},
+		{fileCred.Region, &cred.Region},
+	} {
+		if f.from != "" {
+			*f.to = f.from
+		}
+	}
+	return cred, nil
+}
+
+// cloudsFileCredentials returns the credentials held for the named
+// cloud in the clouds.yaml file at the given path, merged with those
+// in any secure.yaml file in the same directory. If cloudName is
+// empty, the file must hold exactly one cloud. It returns nil if the
+// clouds.yaml file is missing.
+//
+// Application credentials are not supported: goose can only
+// authenticate with a password or an access key pair, and provides
+// no way to add another method.
+func cloudsFileCredentials(path, cloudName string) (*identity.Credentials, error) {
+	clouds, err := readCloudsFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if clouds == nil {
+		logger.Debugf("clouds file %q not found", path)
+		return nil, nil
+	}
+	secure, err := readCloudsFile(filepath.Join(filepath.Dir(path), secureFileName))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cloudName == "" {
+		if len(clouds.Clouds) != 1 {
+			return nil, errors.Errorf("%s must be set to choose one of %d clouds", cloudNameEnvVar, len(clouds.Clouds))
+		}
+		for name := range clouds.Clouds {
+			cloudName = name
+		}
+	}
+	entry, ok := clouds.Clouds[cloudName]
+	if !ok {
+		return nil, errors.NotFoundf("cloud %q", cloudName)
+	}
+	if secure != nil {
+		entry = mergeCloudsFileEntry(entry, secure.Clouds[cloudName])
+	}
+	if entry.AuthType == "v3applicationcredential" || entry.Auth.ApplicationCredentialId != "" {
+		return nil, errors.NotSupportedf("cloud %q: application credentials", cloudName)
+	}
+	tenantName := entry.Auth.ProjectName
+	if tenantName == "" {
+		tenantName = entry.Auth.TenantName
+	}
+	return &identity.Credentials{
+		URL:        entry.Auth.AuthURL,
+		User:       entry.Auth.Username,
+		Secrets:    entry.Auth.Password,
+		TenantName: tenantName,
+		Region:     entry.RegionName,
+	}, nil
+}
+
+// readCloudsFile reads and parses the clouds file at the given path.
+// It returns nil if the file does not exist.
+func readCloudsFile(path string) (*cloudsFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read %q", path)
+	}
+	var clouds cloudsFile
+	if err := goyaml.Unmarshal(data, &clouds); err != nil {
+		return nil, errors.Annotatef(err, "cannot parse %q", path)
+	}
+	return &clouds, nil
+}
+
+// mergeCloudsFileEntry returns the entry with any values set in
+// secure taking precedence.
+func mergeCloudsFileEntry(entry, secure cloudsFileEntry) cloudsFileEntry {
+	for _, f := range []struct {
+		from string
+		to   *string
+	}{
+		{secure.AuthType, &entry.AuthType},
+		{secure.RegionName, &entry.RegionName},
+		{secure.Auth.AuthURL, &entry.Auth.AuthURL},
+		{secure.Auth.Username, &entry.Auth.Username},
+		{secure.Auth.Password, &entry.Auth.Password},
+		{secure.Auth.ProjectName, &entry.Auth.ProjectName},
+		{secure.Auth.TenantName, &entry.Auth.TenantName},
+		{secure.Auth.ApplicationCredentialId, &entry.Auth.ApplicationCredentialId},
+		{secure.Auth.ApplicationCredentialSecret, &entry.Auth.ApplicationCredentialSecret},
+	} {
+		if f.from != "" {
+			*f.to = f.from
+		}
+	}
+	return entry
+}
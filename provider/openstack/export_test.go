@@ -21,6 +21,7 @@ import (
 	"github.com/juju/juju/environs/jujutest"
 	"github.com/juju/juju/environs/simplestreams"
 	envstorage "github.com/juju/juju/environs/storage"
+	"github.com/juju/juju/environs/tags"
 	"github.com/juju/juju/instance"
 	"github.com/juju/juju/network"
 	"github.com/juju/juju/storage"
@@ -67,6 +68,10 @@ func InstanceServerDetail(inst instance.Instance) *nova.ServerDetail {
 	return inst.(*openstackInstance).serverDetail
 }
 
+func MetadataLookupParamsForSeries(e environs.Environ, region, series string, arches []string) (*simplestreams.MetadataLookupParams, error) {
+	return e.(*environ).MetadataLookupParamsForSeries(region, series, arches)
+}
+
 func InstanceFloatingIP(inst instance.Instance) *nova.FloatingIP {
 	return inst.(*openstackInstance).floatingIP
 }
@@ -74,6 +79,16 @@ func InstanceFloatingIP(inst instance.Instance) *nova.FloatingIP {
 var (
 	NovaListAvailabilityZones   = &novaListAvailabilityZones
 	AvailabilityZoneAllocations = &availabilityZoneAllocations
+	NovaServerAction            = &novaServerAction
+	NovaListVolumeAttachments   = &novaListVolumeAttachments
+	NovaDetachVolume            = &novaDetachVolume
+	NovaListServersDetail       = &novaListServersDetail
+	NovaRenameServer            = &novaRenameServer
+	NovaDeleteServerMetadata    = &novaDeleteServerMetadata
+	NovaListFloatingIPs         = &novaListFloatingIPs
+	NovaListNetworks            = &novaListNetworks
+	NovaMaxServerMeta           = &novaMaxServerMeta
+	NovaImageProperties         = &novaImageProperties
 )
 
 type OpenstackStorage openstackStorage
@@ -87,9 +102,13 @@ func NewCinderProvider(s OpenstackStorage) storage.Provider {
 }
 
 func NewCinderVolumeSource(s OpenstackStorage) storage.VolumeSource {
+	return NewCinderVolumeSourceWithPrefix(s, tags.JujuTagPrefix)
+}
+
+func NewCinderVolumeSourceWithPrefix(s OpenstackStorage, metadataKeyPrefix string) storage.VolumeSource {
 	const envName = "testenv"
 	envUUID := testing.EnvironmentTag.Id()
-	return &cinderVolumeSource{openstackStorage(s), envName, envUUID}
+	return &cinderVolumeSource{openstackStorage(s), envName, envUUID, metadataKeyPrefix, cinderAttempt}
 }
 
 var indexData = `
@@ -319,6 +338,27 @@ func SetUseFloatingIP(e environs.Environ, val bool) {
 	env.ecfg().attrs["use-floating-ip"] = val
 }
 
+// AllocatePublicIP exposes environ helper function allocatePublicIP for testing.
+func AllocatePublicIP(e environs.Environ) (*nova.FloatingIP, error) {
+	fip, _, err := e.(*environ).allocatePublicIP()
+	return fip, err
+}
+
+// RecordFloatingIP records the floating IP with the given id on the
+// instance's metadata, as StartInstance does for the floating IPs it
+// allocates.
+func RecordFloatingIP(e environs.Environ, id instance.Id, fipId string) error {
+	env := e.(*environ)
+	return env.nova().SetServerMetadata(string(id), map[string]string{
+		env.metadataKey(floatingIPMetadataKey): fipId,
+	})
+}
+
+// ReleasePublicIP exposes environ helper function releasePublicIP for testing.
+func ReleasePublicIP(e environs.Environ, fip *nova.FloatingIP) {
+	e.(*environ).releasePublicIP(fip)
+}
+
 func SetUpGlobalGroup(e environs.Environ, name string, apiPort int) (nova.SecurityGroup, error) {
 	return e.(*environ).setUpGlobalGroup(name, apiPort)
 }
@@ -366,7 +406,14 @@ func ResolveNetwork(e environs.Environ, networkName string) (string, error) {
 }
 
 var PortsToRuleInfo = portsToRuleInfo
+var RenderInstanceName = renderInstanceName
 var RuleMatchesPortRange = ruleMatchesPortRange
 
 var MakeServiceURL = &makeServiceURL
 var ProviderInstance = providerInstance
+
+var MaxUserDataSize = &maxUserDataSize
+
+func StateServerZones(e environs.Environ, replicas int) ([]string, error) {
+	return e.(*environ).stateServerZones(replicas)
+}
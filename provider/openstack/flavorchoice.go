@@ -0,0 +1,149 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/set"
+
+	"github.com/juju/juju/constraints"
+	"github.com/juju/juju/environs/instances"
+)
+
+// FlavorChoice explains which flavor is chosen for a new machine with
+// some constraints, and why the others are not.
+type FlavorChoice struct {
+	// Selected holds the name of the chosen flavor. It is empty if
+	// no flavor satisfies the constraints.
+	Selected string
+
+	// Reason explains why the selected flavor was chosen, or why
+	// none was.
+	Reason string
+
+	// Candidates holds every flavor considered: those satisfying
+	// the constraints in order of preference, followed by the
+	// rejected ones in name order.
+	Candidates []FlavorCandidate
+}
+
+// FlavorCandidate describes a flavor considered for a new machine.
+type FlavorCandidate struct {
+	Id   string
+	Name string
+
+	// Rejected explains why the flavor cannot be chosen. It is
+	// empty if the flavor satisfies the constraints.
+	Rejected string
+}
+
+// ExplainFlavorChoice reports how a flavor is chosen for a machine
+// with the given constraints, combined with default-constraints as
+// StartInstance does, to help explain why a flavor was picked. Only
+// the flavors are matched: StartInstance also needs an image for the
+// machine's series that suits the flavor, so the flavor it chooses
+// may differ if no such image exists.
+func (e *environ) ExplainFlavorChoice(cons constraints.Value) (*FlavorChoice, error) {
+	cons, err := e.withDefaultConstraints(cons)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	arches, err := e.SupportedArchitectures()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	allInstanceTypes, err := flavorInstanceTypes(e, arches)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot list flavors")
+	}
+	// An error only reports that no flavor matches.
+	matching, _ := instances.MatchingInstanceTypes(allInstanceTypes, "", cons)
+	choice := &FlavorChoice{}
+	chosen := make(map[string]bool)
+	for _, itype := range matching {
+		chosen[itype.Id] = true
+		choice.Candidates = append(choice.Candidates, FlavorCandidate{Id: itype.Id, Name: itype.Name})
+	}
+	var rejected []FlavorCandidate
+	for _, itype := range allInstanceTypes {
+		if chosen[itype.Id] {
+			continue
+		}
+		var reason string
+		if alone, _ := instances.MatchingInstanceTypes([]instances.InstanceType{itype}, "", cons); len(alone) > 0 {
+			// The flavor satisfies the constraints but lost out
+			// to the opinionated default memory size.
+			reason = "not preferred: without a mem constraint, flavors with more memory are chosen"
+		} else if reason = flavorMismatch(itype, cons); reason == "" {
+			reason = "does not satisfy the constraints"
+		}
+		rejected = append(rejected, FlavorCandidate{Id: itype.Id, Name: itype.Name, Rejected: reason})
+	}
+	sort.Sort(byCandidateName(rejected))
+	choice.Candidates = append(choice.Candidates, rejected...)
+
+	if len(matching) == 0 {
+		choice.Reason = fmt.Sprintf("no flavor satisfies constraints %q", cons)
+		return choice, nil
+	}
+	choice.Selected = matching[0].Name
+	choice.Reason = "it is the smallest flavor satisfying the constraints"
+	if len(matching) == 1 {
+		choice.Reason = "it is the only flavor satisfying the constraints"
+	}
+	return choice, nil
+}
+
+// flavorMismatch returns why the given flavor does not satisfy the
+// constraints, or the empty string if it does. The checks follow
+// those made by instances.MatchingInstanceTypes; whether a flavor
+// matches is decided by MatchingInstanceTypes itself, so this only
+// provides the wording.
+func flavorMismatch(itype instances.InstanceType, cons constraints.Value) string {
+	if cons.HasInstanceType() && itype.Name != *cons.InstanceType {
+		return fmt.Sprintf("not the requested instance-type %q", *cons.InstanceType)
+	}
+	if cons.Arch != nil {
+		supported := false
+		for _, arch := range itype.Arches {
+			if arch == *cons.Arch {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Sprintf("does not support arch %q", *cons.Arch)
+		}
+	}
+	if cons.CpuCores != nil && itype.CpuCores < *cons.CpuCores {
+		return fmt.Sprintf("has %d cpu cores, fewer than %d", itype.CpuCores, *cons.CpuCores)
+	}
+	if cons.CpuPower != nil && itype.CpuPower != nil && *itype.CpuPower < *cons.CpuPower {
+		return fmt.Sprintf("has %d cpu power, less than %d", *itype.CpuPower, *cons.CpuPower)
+	}
+	if cons.Mem != nil && itype.Mem < *cons.Mem {
+		return fmt.Sprintf("has %dM memory, less than %dM", itype.Mem, *cons.Mem)
+	}
+	if cons.RootDisk != nil && itype.RootDisk > 0 && itype.RootDisk < *cons.RootDisk {
+		return fmt.Sprintf("has a %dM root disk, smaller than %dM", itype.RootDisk, *cons.RootDisk)
+	}
+	if cons.Tags != nil && len(*cons.Tags) > 0 {
+		have := set.NewStrings(itype.Tags...)
+		for _, tag := range *cons.Tags {
+			if !have.Contains(tag) {
+				return fmt.Sprintf("does not have tag %q", tag)
+			}
+		}
+	}
+	return ""
+}
+
+type byCandidateName []FlavorCandidate
+
+func (s byCandidateName) Len() int           { return len(s) }
+func (s byCandidateName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCandidateName) Less(i, j int) bool { return s[i].Name < s[j].Name }
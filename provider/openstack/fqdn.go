@@ -0,0 +1,54 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/juju/juju/environs/tags"
+)
+
+// fqdnMetadataKey is the Juju tag under which an instance's rendered
+// instance-fqdn-template is stored in its Nova metadata.
+const fqdnMetadataKey = tags.JujuTagPrefix + "fqdn"
+
+// maxFQDNLength is the maximum length of a domain name, excluding any
+// trailing dot.
+const maxFQDNLength = 253
+
+var fqdnLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// renderInstanceFQDN returns the fully qualified domain name for the
+// given machine built from the instance-fqdn-template, returning an
+// error if the result is not a valid domain name.
+func renderInstanceFQDN(template, envName, machineId string) (string, error) {
+	var err error
+	fqdn := instanceNamePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case "{env}":
+			return envName
+		case "{machine}":
+			return machineId
+		}
+		if err == nil {
+			err = fmt.Errorf("unknown placeholder %s in instance-fqdn-template", placeholder)
+		}
+		return placeholder
+	})
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(fqdn, ".")
+	if len(name) > maxFQDNLength {
+		return "", fmt.Errorf("instance FQDN %q is longer than %d characters", fqdn, maxFQDNLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !fqdnLabel.MatchString(label) {
+			return "", fmt.Errorf("instance FQDN %q is not a valid domain name", fqdn)
+		}
+	}
+	return fqdn, nil
+}
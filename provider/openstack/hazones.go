@@ -0,0 +1,91 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"sort"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/environs/tags"
+)
+
+// stateServerZones returns the availability zones in which to start
+// the given number of new state server instances, spreading them, and
+// any state servers already running, as evenly as possible across the
+// zones. The zones are those named in state-server-zones if it is set,
+// or else all the available zones that are not drained. Where zones
+// are equally populated, they are chosen in name order. If no zone is
+// available, an error satisfying errors.IsNotFound is returned.
+func (e *environ) stateServerZones(replicas int) ([]string, error) {
+	if replicas < 1 {
+		return nil, errors.NotValidf("state server count %d", replicas)
+	}
+	candidates, err := e.stateServerCandidateZones()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(candidates) == 0 {
+		return nil, errors.NotFoundf("availability zones for state servers")
+	}
+	population := make(map[string]int)
+	for _, zone := range candidates {
+		population[zone] = 0
+	}
+	instances, err := e.AllInstances()
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot list instances")
+	}
+	for _, inst := range instances {
+		detail := inst.(*openstackInstance).getServerDetail()
+		if detail.Metadata[e.metadataKey(tags.JujuStateServer)] != "true" {
+			continue
+		}
+		if _, ok := population[detail.AvailabilityZone]; ok {
+			population[detail.AvailabilityZone]++
+		}
+	}
+	zones := make([]string, replicas)
+	for i := range zones {
+		best := candidates[0]
+		for _, zone := range candidates[1:] {
+			if population[zone] < population[best] {
+				best = zone
+			}
+		}
+		zones[i] = best
+		population[best]++
+	}
+	return zones, nil
+}
+
+// stateServerCandidateZones returns, in name order, the zones that
+// state servers may be started in.
+func (e *environ) stateServerCandidateZones() ([]string, error) {
+	zones, err := e.AvailabilityZones()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	available := make(map[string]bool)
+	for _, zone := range zones {
+		if zone.Available() && !e.isDrainedZone(zone.Name()) {
+			available[zone.Name()] = true
+		}
+	}
+	var candidates []string
+	if pinned := e.ecfg().stateServerZones(); len(pinned) > 0 {
+		for _, zone := range pinned {
+			if !available[zone] {
+				return nil, errors.Errorf("state-server-zones: availability zone %q is not available", zone)
+			}
+			candidates = append(candidates, zone)
+		}
+	} else {
+		for zone := range available {
+			candidates = append(candidates, zone)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates, nil
+}
@@ -4,16 +4,24 @@
 package openstack
 
 import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/client"
+	goosehttp "gopkg.in/goose.v1/http"
+
+	"github.com/juju/juju/constraints"
 	"github.com/juju/juju/environs"
 	"github.com/juju/juju/environs/imagemetadata"
 	"github.com/juju/juju/environs/instances"
 	"github.com/juju/juju/environs/simplestreams"
 )
 
-// findInstanceSpec returns an image and instance type satisfying the constraint.
-// The instance type comes from querying the flavors supported by the deployment.
-func findInstanceSpec(e *environ, ic *instances.InstanceConstraint) (*instances.InstanceSpec, error) {
-	// first construct all available instance types from the supported flavors.
+// flavorInstanceTypes returns an instance type for each flavor
+// supported by the deployment, each supporting the given arches.
+func flavorInstanceTypes(e *environ, arches []string) ([]instances.InstanceType, error) {
 	nova := e.nova()
 	flavors, err := nova.ListFlavorsDetail()
 	if err != nil {
@@ -24,7 +32,7 @@ func findInstanceSpec(e *environ, ic *instances.InstanceConstraint) (*instances.
 		instanceType := instances.InstanceType{
 			Id:       flavor.Id,
 			Name:     flavor.Name,
-			Arches:   ic.Arches,
+			Arches:   arches,
 			Mem:      uint64(flavor.RAM),
 			CpuCores: uint64(flavor.VCPUs),
 			RootDisk: uint64(flavor.Disk * 1024),
@@ -32,26 +40,150 @@ func findInstanceSpec(e *environ, ic *instances.InstanceConstraint) (*instances.
 		}
 		allInstanceTypes = append(allInstanceTypes, instanceType)
 	}
+	return allInstanceTypes, nil
+}
 
-	imageConstraint := imagemetadata.NewImageConstraint(simplestreams.LookupParams{
-		CloudSpec: simplestreams.CloudSpec{ic.Region, e.ecfg().authURL()},
-		Series:    []string{ic.Series},
-		Arches:    ic.Arches,
-		Stream:    e.Config().ImageStream(),
-	})
-	sources, err := environs.ImageMetadataSources(e)
+// checkFlavorsSatisfy returns an error if no flavor supported by the
+// deployment satisfies the given constraints.
+func checkFlavorsSatisfy(e *environ, cons constraints.Value) error {
+	arches, err := e.SupportedArchitectures()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	allInstanceTypes, err := flavorInstanceTypes(e, arches)
+	if err != nil {
+		return errors.Annotate(err, "cannot list flavors")
+	}
+	matching, err := instances.MatchingInstanceTypes(allInstanceTypes, "", cons)
+	if err != nil || len(matching) == 0 {
+		return errors.Errorf("no flavor satisfies constraints %q", cons)
+	}
+	return nil
+}
+
+// findInstanceSpec returns an image and instance type satisfying the constraint.
+// The instance type comes from querying the flavors supported by the deployment.
+func findInstanceSpec(e *environ, ic *instances.InstanceConstraint) (*instances.InstanceSpec, error) {
+	// first construct all available instance types from the supported flavors.
+	allInstanceTypes, err := flavorInstanceTypes(e, ic.Arches)
 	if err != nil {
 		return nil, err
 	}
-	// TODO (wallyworld): use an env parameter (default true) to mandate use of only signed image metadata.
-	matchingImages, _, err := imagemetadata.Fetch(sources, imageConstraint, false)
+
+	sources, err := environs.ImageMetadataSources(e)
 	if err != nil {
 		return nil, err
 	}
-	images := instances.ImageMetadataToImages(matchingImages)
-	spec, err := instances.FindInstanceSpec(images, ic, allInstanceTypes)
+	exclude, err := e.ecfg().imageExcludePattern()
 	if err != nil {
 		return nil, err
 	}
-	return spec, nil
+	requiredProperties := e.ecfg().imageRequiredProperties()
+	// Search the configured image streams in order of preference,
+	// using the first that has a suitable image.
+	streams := e.ecfg().imageStreams()
+	for i, stream := range streams {
+		imageConstraint := imagemetadata.NewImageConstraint(simplestreams.LookupParams{
+			CloudSpec: simplestreams.CloudSpec{ic.Region, e.ecfg().authURL()},
+			Series:    []string{ic.Series},
+			Arches:    ic.Arches,
+			Stream:    stream,
+		})
+		// TODO (wallyworld): use an env parameter (default true) to mandate use of only signed image metadata.
+		var matchingImages []*imagemetadata.ImageMetadata
+		matchingImages, _, err = imagemetadata.Fetch(sources, imageConstraint, false)
+		if err == nil {
+			matchingImages = excludeImages(matchingImages, exclude)
+			matchingImages, err = e.imagesWithProperties(matchingImages, requiredProperties)
+		}
+		if err == nil {
+			images := instances.ImageMetadataToImages(matchingImages)
+			var spec *instances.InstanceSpec
+			spec, err = instances.FindInstanceSpec(images, ic, allInstanceTypes)
+			if err == nil {
+				return spec, nil
+			}
+		}
+		if i < len(streams)-1 {
+			logger.Debugf("no suitable image in stream %q: %v", stream, err)
+		}
+	}
+	return nil, err
+}
+
+// excludeImages returns the images whose ids do not match the given
+// expression. If exclude is nil, all the images are returned.
+func excludeImages(images []*imagemetadata.ImageMetadata, exclude *regexp.Regexp) []*imagemetadata.ImageMetadata {
+	if exclude == nil {
+		return images
+	}
+	var result []*imagemetadata.ImageMetadata
+	for _, image := range images {
+		if exclude.MatchString(image.Id) {
+			logger.Debugf("excluding image %q", image.Id)
+			continue
+		}
+		result = append(result, image)
+	}
+	return result
+}
+
+// novaImageProperties returns the properties of the specified image
+// as reported by Glance through Nova. Goose does not expose image
+// metadata, so the request is sent directly using the authenticated
+// client.
+var novaImageProperties = func(c client.Client, imageId string) (map[string]string, error) {
+	var resp struct {
+		Image struct {
+			Metadata map[string]interface{} `json:"metadata"`
+		} `json:"image"`
+	}
+	requestData := goosehttp.RequestData{
+		RespValue:      &resp,
+		ExpectedStatus: []int{http.StatusOK},
+	}
+	url := fmt.Sprintf("images/%s", imageId)
+	if err := c.SendRequest(client.GET, "compute", url, &requestData); err != nil {
+		return nil, err
+	}
+	properties := make(map[string]string, len(resp.Image.Metadata))
+	for k, v := range resp.Image.Metadata {
+		properties[k] = fmt.Sprint(v)
+	}
+	return properties, nil
+}
+
+// imagesWithProperties returns the images that have all the required
+// properties with the required values. If no properties are required,
+// all the images are returned.
+func (e *environ) imagesWithProperties(
+	images []*imagemetadata.ImageMetadata, required map[string]string,
+) ([]*imagemetadata.ImageMetadata, error) {
+	if len(required) == 0 {
+		return images, nil
+	}
+	var result []*imagemetadata.ImageMetadata
+	for _, image := range images {
+		properties, err := novaImageProperties(e.client, image.Id)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot get properties of image %q", image.Id)
+		}
+		if missing := missingProperty(properties, required); missing != "" {
+			logger.Debugf("skipping image %q: property %s is not %q", image.Id, missing, required[missing])
+			continue
+		}
+		result = append(result, image)
+	}
+	return result, nil
+}
+
+// missingProperty returns the name of a required property that is not
+// set to the required value, or "" if all are.
+func missingProperty(properties, required map[string]string) string {
+	for k, v := range required {
+		if properties[k] != v {
+			return k
+		}
+	}
+	return ""
 }
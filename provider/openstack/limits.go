@@ -0,0 +1,78 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/client"
+	goosehttp "gopkg.in/goose.v1/http"
+
+	"github.com/juju/juju/environs/tags"
+)
+
+// novaMaxServerMeta returns the maximum number of metadata items
+// Nova allows on a server. Goose does not expose the limits API, so
+// the request is sent directly using the authenticated client.
+var novaMaxServerMeta = func(c client.Client) (int, error) {
+	var resp struct {
+		Limits struct {
+			Absolute struct {
+				MaxServerMeta int `json:"maxServerMeta"`
+			} `json:"absolute"`
+		} `json:"limits"`
+	}
+	requestData := goosehttp.RequestData{
+		RespValue:      &resp,
+		ExpectedStatus: []int{http.StatusOK},
+	}
+	if err := c.SendRequest(client.GET, "compute", "limits", &requestData); err != nil {
+		return 0, err
+	}
+	return resp.Limits.Absolute.MaxServerMeta, nil
+}
+
+// checkServerMetadata returns an error if the given server metadata
+// would exceed Nova's limits, naming the metadata items that could be
+// dropped. Any failure to read the limits is logged and ignored.
+func (e *environ) checkServerMetadata(metadata map[string]string) error {
+	var tooLarge []string
+	for k, v := range metadata {
+		if len(k) > maxMetadataLength || len(v) > maxMetadataLength {
+			tooLarge = append(tooLarge, k)
+		}
+	}
+	if len(tooLarge) > 0 {
+		sort.Strings(tooLarge)
+		return errors.Errorf(
+			"metadata entries too large: keys and values must be at most %d characters; drop %s",
+			maxMetadataLength, strings.Join(tooLarge, ", "),
+		)
+	}
+	maxItems, err := novaMaxServerMeta(e.client)
+	if err != nil {
+		logger.Debugf("cannot get server metadata limit: %v", err)
+		return nil
+	}
+	if maxItems <= 0 || len(metadata) <= maxItems {
+		return nil
+	}
+	// The metadata Juju sets itself cannot be dropped, so
+	// only suggest removing resource tags.
+	var droppable []string
+	prefix := e.ecfg().metadataKeyPrefix()
+	for k := range metadata {
+		if !strings.HasPrefix(k, prefix) && !strings.HasPrefix(k, tags.JujuTagPrefix) {
+			droppable = append(droppable, k)
+		}
+	}
+	sort.Strings(droppable)
+	return errors.Errorf(
+		"too many metadata entries: %d exceeds the limit of %d; drop %d of the resource tags %s",
+		len(metadata), maxItems, len(metadata)-maxItems, strings.Join(droppable, ", "),
+	)
+}
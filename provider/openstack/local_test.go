@@ -8,17 +8,22 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	jujuerrors "github.com/juju/errors"
 	gitjujutesting "github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
+	"github.com/juju/utils/set"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/goose.v1/cinder"
 	"gopkg.in/goose.v1/client"
 	"gopkg.in/goose.v1/identity"
 	"gopkg.in/goose.v1/nova"
@@ -35,9 +40,11 @@ import (
 	"github.com/juju/juju/environs/configstore"
 	"github.com/juju/juju/environs/filestorage"
 	"github.com/juju/juju/environs/imagemetadata"
+	"github.com/juju/juju/environs/instances"
 	"github.com/juju/juju/environs/jujutest"
 	"github.com/juju/juju/environs/simplestreams"
 	"github.com/juju/juju/environs/storage"
+	"github.com/juju/juju/environs/tags"
 	envtesting "github.com/juju/juju/environs/testing"
 	"github.com/juju/juju/environs/tools"
 	"github.com/juju/juju/instance"
@@ -333,6 +340,29 @@ func (s *localServerSuite) TestAddressesWithoutPublicIP(c *gc.C) {
 	c.Assert(bootstrapFinished, jc.IsTrue)
 }
 
+func (s *localServerSuite) TestBootstrapNoProxyIncludesAuthURLHost(c *gc.C) {
+	var noProxy string
+	s.PatchValue(&common.FinishBootstrap, func(ctx environs.BootstrapContext, client ssh.Client, inst instance.Instance, instanceConfig *instancecfg.InstanceConfig) error {
+		noProxy = instanceConfig.ProxySettings.NoProxy
+		return nil
+	})
+
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"no-proxy": "example.com",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	authURL, err := url.Parse(s.cred.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	authHost, _, err := net.SplitHostPort(authURL.Host)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(strings.Split(noProxy, ","), jc.SameContents, []string{"example.com", authHost})
+}
+
 // If the environment is configured not to require a public IP address for nodes,
 // bootstrapping and starting an instance should occur without any attempt to
 // allocate a public address.
@@ -427,6 +457,160 @@ func (s *localServerSuite) TestStartInstanceNetworkUnknownId(c *gc.C) {
 		"404; error info: .*itemNotFound.*")
 }
 
+func (s *localServerSuite) openEnvironWithNetwork(c *gc.C, cidr string) environs.Environ {
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		// A label that corresponds to a nova test service network
+		"network": "net",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	listNetworks := *openstack.NovaListNetworks
+	s.PatchValue(openstack.NovaListNetworks, func(nc *nova.Client) ([]nova.Network, error) {
+		networks, err := listNetworks(nc)
+		for i := range networks {
+			networks[i].Cidr = &cidr
+		}
+		return networks, err
+	})
+	return env
+}
+
+func (s *localServerSuite) prepareWithPrivateNetworks(c *gc.C, attrs coretesting.Attrs) error {
+	listNetworks := *openstack.NovaListNetworks
+	s.PatchValue(openstack.NovaListNetworks, func(nc *nova.Client) ([]nova.Network, error) {
+		networks, err := listNetworks(nc)
+		for i := range networks {
+			cidr := fmt.Sprintf("10.%d.0.0/24", i)
+			networks[i].Cidr = &cidr
+		}
+		return networks, err
+	})
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(attrs))
+	c.Assert(err, jc.ErrorIsNil)
+	provider, err := environs.Provider("openstack")
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = provider.PrepareForBootstrap(envtesting.BootstrapContext(c), cfg)
+	return err
+}
+
+func (s *localServerSuite) TestPrepareForBootstrapPrivateNetworksError(c *gc.C) {
+	err := s.prepareWithPrivateNetworks(c, coretesting.Attrs{
+		"use-floating-ip":            false,
+		"network-reachability-check": "error",
+	})
+	c.Assert(err, gc.ErrorMatches, "use-floating-ip is false and the only networks available are private: .*")
+}
+
+func (s *localServerSuite) TestPrepareForBootstrapPrivateNetworksWarn(c *gc.C) {
+	err := s.prepareWithPrivateNetworks(c, coretesting.Attrs{
+		"use-floating-ip": false,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(c.GetTestLog(), jc.Contains, "the only networks available are private")
+}
+
+func (s *localServerSuite) prepareWithListNetworksError(c *gc.C, attrs coretesting.Attrs) error {
+	s.PatchValue(openstack.NovaListNetworks, func(*nova.Client) ([]nova.Network, error) {
+		return nil, fmt.Errorf("failed on purpose")
+	})
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(attrs))
+	c.Assert(err, jc.ErrorIsNil)
+	provider, err := environs.Provider("openstack")
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = provider.PrepareForBootstrap(envtesting.BootstrapContext(c), cfg)
+	return err
+}
+
+func (s *localServerSuite) TestPrepareForBootstrapListNetworksErrorWarn(c *gc.C) {
+	err := s.prepareWithListNetworksError(c, coretesting.Attrs{
+		"use-floating-ip": false,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(c.GetTestLog(), jc.Contains, "cannot list networks to check that the bootstrap instance will be reachable: failed on purpose")
+}
+
+func (s *localServerSuite) TestPrepareForBootstrapListNetworksErrorError(c *gc.C) {
+	err := s.prepareWithListNetworksError(c, coretesting.Attrs{
+		"use-floating-ip":            false,
+		"network-reachability-check": "error",
+	})
+	c.Assert(err, gc.ErrorMatches, "cannot list networks: failed on purpose")
+}
+
+func (s *localServerSuite) TestPrepareForBootstrapPrivateNetworksWithFloatingIP(c *gc.C) {
+	err := s.prepareWithPrivateNetworks(c, coretesting.Attrs{
+		"use-floating-ip":            true,
+		"network-reachability-check": "error",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *localServerSuite) TestPrepareForBootstrapWithoutCinder(c *gc.C) {
+	// The test service's catalog has no volume endpoint.
+	cfg, err := config.New(config.NoDefaults, s.TestConfig)
+	c.Assert(err, jc.ErrorIsNil)
+	provider, err := environs.Provider("openstack")
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := provider.PrepareForBootstrap(envtesting.BootstrapContext(c), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	_, ok := env.Config().StorageDefaultBlockSource()
+	c.Assert(ok, jc.IsFalse)
+}
+
+func (s *localServerSuite) TestStartInstanceFixedIP(c *gc.C) {
+	env := s.openEnvironWithNetwork(c, "10.1.0.0/24")
+	params := environs.StartInstanceParams{Placement: "fixed-ip=10.1.0.50"}
+	result, err := testing.StartInstanceWithParams(env, "100", params, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.StopInstances(result.Instance.Id())
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *localServerSuite) TestStartInstanceFixedIPOutsideNetwork(c *gc.C) {
+	env := s.openEnvironWithNetwork(c, "10.1.0.0/24")
+	params := environs.StartInstanceParams{Placement: "fixed-ip=10.2.0.50"}
+	_, err := testing.StartInstanceWithParams(env, "100", params, nil)
+	c.Assert(err, gc.ErrorMatches, `fixed IP 10.2.0.50 is not within network "net" \(10.1.0.0/24\)`)
+}
+
+func (s *localServerSuite) TestStartInstanceFixedIPInUse(c *gc.C) {
+	env := s.openEnvironWithNetwork(c, "0.0.0.0/0")
+	inst, _ := testing.AssertStartInstance(c, env, "100")
+	defer func() {
+		err := env.StopInstances(inst.Id())
+		c.Assert(err, jc.ErrorIsNil)
+	}()
+	addresses, err := inst.Addresses()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(addresses, gc.Not(gc.HasLen), 0)
+	var inUse string
+	for _, address := range addresses {
+		if address.Type == network.IPv4Address {
+			inUse = address.Value
+			break
+		}
+	}
+	c.Assert(inUse, gc.Not(gc.Equals), "")
+
+	params := environs.StartInstanceParams{Placement: "fixed-ip=" + inUse}
+	_, err = testing.StartInstanceWithParams(env, "101", params, nil)
+	c.Assert(err, gc.ErrorMatches, fmt.Sprintf(`fixed IP %s is already in use by instance %q`, inUse, inst.Id()))
+}
+
+func (s *localServerSuite) TestStartInstanceFixedIPWithoutNetwork(c *gc.C) {
+	env := s.Prepare(c)
+	params := environs.StartInstanceParams{Placement: "fixed-ip=10.1.0.50"}
+	_, err := testing.StartInstanceWithParams(env, "100", params, nil)
+	c.Assert(err, gc.ErrorMatches, `cannot use fixed IP 10.1.0.50: the network setting must name the network to use`)
+}
+
+func (s *localServerSuite) TestPrecheckInstanceInvalidFixedIP(c *gc.C) {
+	env := s.Prepare(c)
+	err := env.PrecheckInstance(coretesting.FakeDefaultSeries, constraints.Value{}, "fixed-ip=not-an-ip")
+	c.Assert(err, gc.ErrorMatches, `invalid fixed IP address "not-an-ip"`)
+}
+
 func assertSecurityGroups(c *gc.C, env environs.Environ, expected []string) {
 	novaClient := openstack.GetNovaClient(env)
 	groups, err := novaClient.ListSecurityGroups()
@@ -506,6 +690,80 @@ func (s *localServerSuite) TestStopInstanceSecurityGroupNotDeleted(c *gc.C) {
 	assertSecurityGroups(c, env, allSecurityGroups)
 }
 
+func assertServerSecurityGroups(c *gc.C, env environs.Environ, inst instance.Instance, expected []string) {
+	novaClient := openstack.GetNovaClient(env)
+	groups, err := novaClient.GetServerSecurityGroups(string(inst.Id()))
+	c.Assert(err, jc.ErrorIsNil)
+	var names []string
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+	c.Assert(names, jc.SameContents, expected)
+}
+
+func (s *localServerSuite) TestStartInstanceWithExistingSecurityGroups(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"firewall-mode":   config.FwInstance,
+		"security-groups": "existing",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = openstack.GetNovaClient(env).CreateSecurityGroup("existing", "pre-existing group")
+	c.Assert(err, jc.ErrorIsNil)
+
+	instanceName := "100"
+	inst, _ := testing.AssertStartInstance(c, env, instanceName)
+	name := env.Config().Name()
+	assertServerSecurityGroups(c, env, inst, []string{
+		fmt.Sprintf("juju-%v", name), fmt.Sprintf("juju-%v-%v", name, instanceName), "existing",
+	})
+	err = env.StopInstances(inst.Id())
+	c.Assert(err, jc.ErrorIsNil)
+	// The existing group is left alone.
+	assertSecurityGroups(c, env, []string{"default", fmt.Sprintf("juju-%v", name), "existing"})
+}
+
+func (s *localServerSuite) TestStartInstanceWithOnlyExistingSecurityGroups(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"firewall-mode":          config.FwNone,
+		"security-groups":        "existing",
+		"manage-security-groups": false,
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = openstack.GetNovaClient(env).CreateSecurityGroup("existing", "pre-existing group")
+	c.Assert(err, jc.ErrorIsNil)
+
+	inst, _ := testing.AssertStartInstance(c, env, "100")
+	assertServerSecurityGroups(c, env, inst, []string{"existing"})
+	// No juju security groups were created.
+	assertSecurityGroups(c, env, []string{"default", "existing"})
+}
+
+func (s *localServerSuite) TestBootstrapNoFlavorSatisfiesConstraints(c *gc.C) {
+	env := s.Prepare(c)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		Constraints: constraints.MustParse("mem=1T"),
+	})
+	c.Assert(err, gc.ErrorMatches, `no flavor satisfies constraints "mem=1048576M"`)
+	insts, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 0)
+}
+
+func (s *localServerSuite) TestBootstrapMissingExistingSecurityGroup(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"security-groups": "missing",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, gc.ErrorMatches, `loading security group "missing": .*`)
+}
+
 func (s *localServerSuite) TestDestroyEnvironmentDeletesSecurityGroupsFWModeInstance(c *gc.C) {
 	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
 		"firewall-mode": config.FwInstance}))
@@ -617,6 +875,213 @@ func (s *localServerSuite) TestAllInstancesFloatingIP(c *gc.C) {
 	}
 }
 
+func (s *localServerSuite) TestReconcileFloatingIPsReleasesDangling(c *gc.C) {
+	env := s.Prepare(c)
+	novaClient := openstack.GetNovaClient(env)
+	inst, _ := testing.AssertStartInstance(c, env, "100")
+	defer func() {
+		err := env.StopInstances(inst.Id())
+		c.Assert(err, jc.ErrorIsNil)
+	}()
+
+	// One floating IP allocated by juju for the instance but never
+	// assigned, and another allocated by someone else sharing the
+	// tenant. A new environ has no memory of either, as after a
+	// restart.
+	jujuIP, err := novaClient.AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+	err = openstack.RecordFloatingIP(env, inst.Id(), jujuIP.Id)
+	c.Assert(err, jc.ErrorIsNil)
+	otherIP, err := novaClient.AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+
+	env, err = environs.New(env.Config())
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.(environs.FloatingIPReconciler).ReconcileFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+
+	fips, err := novaClient.ListFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fips, gc.HasLen, 1)
+	c.Assert(fips[0].IP, gc.Equals, otherIP.IP)
+}
+
+func (s *localServerSuite) TestReconcileFloatingIPsKeepsReserved(c *gc.C) {
+	env := s.Prepare(c)
+	novaClient := openstack.GetNovaClient(env)
+	inst, _ := testing.AssertStartInstance(c, env, "100")
+	defer func() {
+		err := env.StopInstances(inst.Id())
+		c.Assert(err, jc.ErrorIsNil)
+	}()
+
+	// An address chosen for an instance that is still starting must
+	// not be released from under it.
+	jujuIP, err := openstack.AllocatePublicIP(env)
+	c.Assert(err, jc.ErrorIsNil)
+	err = openstack.RecordFloatingIP(env, inst.Id(), jujuIP.Id)
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.(environs.FloatingIPReconciler).ReconcileFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+
+	fips, err := novaClient.ListFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fips, gc.HasLen, 1)
+	c.Assert(fips[0].IP, gc.Equals, jujuIP.IP)
+}
+
+func (s *localServerSuite) TestStartInstanceReleasesUnassignedFloatingIP(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"use-floating-ip": true,
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	cleanup := s.srv.Nova.RegisterControlPoint(
+		"addServerFloatingIP",
+		func(sc hook.ServiceControl, args ...interface{}) error {
+			return fmt.Errorf("failed on purpose")
+		},
+	)
+	defer cleanup()
+
+	_, _, _, err = testing.StartInstance(env, "100")
+	c.Assert(err, gc.ErrorMatches, "cannot assign public address .*: failed on purpose")
+	fips, err := openstack.GetNovaClient(env).ListFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fips, gc.HasLen, 0)
+}
+
+func (s *localServerSuite) TestAllocatePublicIPSkipsReserved(c *gc.C) {
+	env := s.Prepare(c)
+	fip, err := openstack.GetNovaClient(env).AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+
+	fip0, err := openstack.AllocatePublicIP(env)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fip0.IP, gc.Equals, fip.IP)
+	fip1, err := openstack.AllocatePublicIP(env)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fip1.IP, gc.Not(gc.Equals), fip.IP)
+}
+
+func (s *localServerSuite) TestAllocatePublicIPReusesUnassociated(c *gc.C) {
+	env := s.Prepare(c)
+	novaClient := openstack.GetNovaClient(env)
+	fip, err := novaClient.AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+
+	fip0, err := openstack.AllocatePublicIP(env)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fip0.IP, gc.Equals, fip.IP)
+	fips, err := novaClient.ListFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fips, gc.HasLen, 1)
+}
+
+func (s *localServerSuite) TestAllocatePublicIPWithoutReuse(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"reuse-floating-ips": false,
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	novaClient := openstack.GetNovaClient(env)
+	fip, err := novaClient.AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+
+	fip0, err := openstack.AllocatePublicIP(env)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fip0.IP, gc.Not(gc.Equals), fip.IP)
+	fips, err := novaClient.ListFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fips, gc.HasLen, 2)
+}
+
+func (s *localServerSuite) TestAllocatePublicIPConcurrency(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"floating-ip-concurrency": 2,
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	listFloatingIPs := *openstack.NovaListFloatingIPs
+	s.PatchValue(openstack.NovaListFloatingIPs, func(nc *nova.Client) ([]nova.FloatingIP, error) {
+		started <- struct{}{}
+		<-release
+		return listFloatingIPs(nc)
+	})
+
+	const n = 4
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := openstack.AllocatePublicIP(env)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(coretesting.LongWait):
+			c.Fatalf("timed out waiting for allocation %d to start", i)
+		}
+	}
+	select {
+	case <-started:
+		c.Fatalf("more than 2 allocations in progress")
+	case <-time.After(coretesting.ShortWait):
+	}
+	close(release)
+	for i := 2; i < n; i++ {
+		select {
+		case <-started:
+		case <-time.After(coretesting.LongWait):
+			c.Fatalf("timed out waiting for allocation %d to start", i)
+		}
+	}
+	for i := 0; i < n; i++ {
+		c.Assert(<-errs, jc.ErrorIsNil)
+	}
+}
+
+func (s *localServerSuite) TestReconcileFloatingIPsReassociates(c *gc.C) {
+	env := s.Prepare(c)
+	novaClient := openstack.GetNovaClient(env)
+	inst, _ := testing.AssertStartInstance(c, env, "100")
+	defer func() {
+		err := env.StopInstances(inst.Id())
+		c.Assert(err, jc.ErrorIsNil)
+	}()
+	openstack.SetUseFloatingIP(env, true)
+
+	// An unassociated address juju did not allocate is not given to
+	// the instance, even though it lacks one.
+	otherIP, err := novaClient.AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.(environs.FloatingIPReconciler).ReconcileFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+	insts, err := env.Instances([]instance.Id{inst.Id()})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(openstack.InstanceFloatingIP(insts[0]), gc.IsNil)
+
+	jujuIP, err := novaClient.AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+	err = openstack.RecordFloatingIP(env, inst.Id(), jujuIP.Id)
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.(environs.FloatingIPReconciler).ReconcileFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+
+	insts, err = env.Instances([]instance.Id{inst.Id()})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(openstack.InstanceFloatingIP(insts[0]), gc.NotNil)
+	c.Assert(openstack.InstanceFloatingIP(insts[0]).IP, gc.Equals, jujuIP.IP)
+	c.Assert(openstack.InstanceFloatingIP(insts[0]).IP, gc.Not(gc.Equals), otherIP.IP)
+}
+
 func (s *localServerSuite) assertInstancesGathering(c *gc.C, withFloatingIP bool) {
 	// Create a config that matches s.TestConfig but with use-floating-ip
 	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
@@ -738,20 +1203,219 @@ func (s *localServerSuite) TestInstancesShutoffSuspended(c *gc.C) {
 	c.Assert(instances[1].Status(), gc.Equals, nova.StatusSuspended)
 }
 
-func (s *localServerSuite) TestInstancesErrorResponse(c *gc.C) {
-	coretesting.SkipIfPPC64EL(c, "lp:1425242")
-
+func (s *localServerSuite) TestAllInstancesShowUnhealthyServers(c *gc.C) {
 	env := s.Prepare(c)
+	inst, _ := testing.AssertStartInstance(c, env, "100")
+	name := fmt.Sprintf("juju-%s-machine-101", env.Config().Name())
 	cleanup := s.srv.Nova.RegisterControlPoint(
-		"server",
+		"addServer",
 		func(sc hook.ServiceControl, args ...interface{}) error {
-			return fmt.Errorf("strange error not instance")
+			details := args[0].(*nova.ServerDetail)
+			if details.Name == name {
+				details.Status = "ERROR"
+			}
+			return nil
 		},
 	)
 	defer cleanup()
+	broken, err := openstack.GetNovaClient(env).RunServer(nova.RunServerOpts{
+		Name:     name,
+		FlavorId: "1",
+		ImageId:  "1",
+	})
+	c.Assert(err, jc.ErrorIsNil)
 
-	instances, err := env.Instances([]instance.Id{"1"})
-	c.Check(instances, gc.IsNil)
+	// By default the server in the ERROR state is not listed.
+	insts, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 1)
+	c.Assert(insts[0].Id(), gc.Equals, inst.Id())
+
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"show-unhealthy-servers": true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	insts, err = env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 2)
+	statuses := make(map[instance.Id]string)
+	for _, inst := range insts {
+		statuses[inst.Id()] = inst.Status()
+	}
+	c.Assert(statuses, jc.DeepEquals, map[instance.Id]string{
+		inst.Id():              nova.StatusActive,
+		instance.Id(broken.Id): "ERROR (unhealthy)",
+	})
+	insts, err = env.Instances([]instance.Id{instance.Id(broken.Id)})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts[0].Status(), gc.Equals, "ERROR (unhealthy)")
+}
+
+func (s *localServerSuite) TestStartInstanceUserDataTooLarge(c *gc.C) {
+	env := s.Prepare(c)
+	s.PatchValue(openstack.MaxUserDataSize, 100)
+	_, _, _, err := testing.StartInstance(env, "100")
+	c.Assert(err, gc.ErrorMatches, `user data is \d+ bytes once compressed and encoded, more than the 100 bytes allowed by Nova`)
+	insts, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 0)
+}
+
+// setDestroyMode sets the environment's destroy-mode.
+func setDestroyMode(c *gc.C, env environs.Environ, mode string) {
+	cfg, err := env.Config().Apply(map[string]interface{}{"destroy-mode": mode})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+// patchSoftDestroy patches the Nova calls made by a stop-mode destroy,
+// reporting the given volume attached to each server and recording the
+// servers stopped and the volumes detached.
+func patchSoftDestroy(s *localServerSuite, c *gc.C, volumeId string) (stopped, detached map[string]bool) {
+	stopped = make(map[string]bool)
+	detached = make(map[string]bool)
+	s.PatchValue(openstack.NovaServerAction, func(_ client.Client, serverId, action string) error {
+		c.Check(action, gc.Equals, "os-stop")
+		stopped[serverId] = true
+		return nil
+	})
+	s.PatchValue(openstack.NovaListVolumeAttachments, func(_ *nova.Client, serverId string) ([]nova.VolumeAttachment, error) {
+		if detached[serverId] {
+			return nil, nil
+		}
+		return []nova.VolumeAttachment{{
+			Id:       volumeId,
+			VolumeId: volumeId,
+			ServerId: serverId,
+		}}, nil
+	})
+	s.PatchValue(openstack.NovaDetachVolume, func(_ *nova.Client, serverId, attachmentId string) error {
+		c.Check(attachmentId, gc.Equals, volumeId)
+		detached[serverId] = true
+		return nil
+	})
+	return stopped, detached
+}
+
+func (s *localServerSuite) TestDestroyStopModeThenDestroyAgain(c *gc.C) {
+	env := s.Prepare(c)
+	inst0, _ := testing.AssertStartInstance(c, env, "100")
+	inst1, _ := testing.AssertStartInstance(c, env, "101")
+	novaClient := openstack.GetNovaClient(env)
+	fip, err := novaClient.AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+	err = novaClient.AddServerFloatingIP(string(inst0.Id()), fip.IP)
+	c.Assert(err, jc.ErrorIsNil)
+	// A floating IP not associated with an instance is left alone.
+	spare, err := novaClient.AllocateFloatingIP()
+	c.Assert(err, jc.ErrorIsNil)
+
+	stopped, detached := patchSoftDestroy(s, c, "vol-0")
+	setDestroyMode(c, env, "stop")
+	err = environs.Destroy(env, s.ConfigStore)
+	c.Assert(err, jc.ErrorIsNil)
+	ids := map[string]bool{
+		string(inst0.Id()): true,
+		string(inst1.Id()): true,
+	}
+	c.Assert(stopped, jc.DeepEquals, ids)
+	c.Assert(detached, jc.DeepEquals, ids)
+
+	// The instances, security groups and environment info remain,
+	// but the floating IPs are released.
+	insts, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 2)
+	_, err = novaClient.SecurityGroupByName("juju-" + env.Config().Name())
+	c.Assert(err, jc.ErrorIsNil)
+	fips, err := novaClient.ListFloatingIPs()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(fips, gc.HasLen, 1)
+	c.Assert(fips[0].Id, gc.Equals, spare.Id)
+	_, err = s.ConfigStore.ReadInfo(env.Config().Name())
+	c.Assert(err, jc.ErrorIsNil)
+
+	// Destroying the stopped environment again, with the same
+	// configuration, removes everything.
+	err = environs.Destroy(env, s.ConfigStore)
+	c.Assert(err, jc.ErrorIsNil)
+	insts, err = env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 0)
+	_, err = novaClient.SecurityGroupByName("juju-" + env.Config().Name())
+	c.Assert(err, gc.NotNil)
+	_, err = s.ConfigStore.ReadInfo(env.Config().Name())
+	c.Assert(err, jc.Satisfies, jujuerrors.IsNotFound)
+}
+
+func (s *localServerSuite) TestDestroyStopModeThenTerminate(c *gc.C) {
+	env := s.Prepare(c)
+	testing.AssertStartInstance(c, env, "100")
+	patchSoftDestroy(s, c, "vol-0")
+	setDestroyMode(c, env, "stop")
+	err := env.Destroy()
+	c.Assert(err, gc.Equals, environs.ErrEnvironStopped)
+
+	setDestroyMode(c, env, "terminate")
+	err = environs.Destroy(env, s.ConfigStore)
+	c.Assert(err, jc.ErrorIsNil)
+	insts, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 0)
+	_, err = s.ConfigStore.ReadInfo(env.Config().Name())
+	c.Assert(err, jc.Satisfies, jujuerrors.IsNotFound)
+}
+
+func (s *localServerSuite) TestDestroyStopModeDetachError(c *gc.C) {
+	env := s.Prepare(c)
+	inst, _ := testing.AssertStartInstance(c, env, "100")
+	defer func() {
+		err := env.StopInstances(inst.Id())
+		c.Assert(err, jc.ErrorIsNil)
+	}()
+	patchSoftDestroy(s, c, "vol-0")
+	s.PatchValue(openstack.NovaDetachVolume, func(*nova.Client, string, string) error {
+		return fmt.Errorf("failed on purpose")
+	})
+	setDestroyMode(c, env, "stop")
+	err := environs.Destroy(env, s.ConfigStore)
+	c.Assert(err, gc.ErrorMatches, `cannot detach volume vol-0 from instance ".*": failed on purpose`)
+	_, err = s.ConfigStore.ReadInfo(env.Config().Name())
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *localServerSuite) TestDestroyStopModeStopError(c *gc.C) {
+	env := s.Prepare(c)
+	inst, _ := testing.AssertStartInstance(c, env, "100")
+	defer func() {
+		err := env.StopInstances(inst.Id())
+		c.Assert(err, jc.ErrorIsNil)
+	}()
+	s.PatchValue(openstack.NovaServerAction, func(client.Client, string, string) error {
+		return fmt.Errorf("failed on purpose")
+	})
+	setDestroyMode(c, env, "stop")
+	err := env.Destroy()
+	c.Assert(err, gc.ErrorMatches, `cannot perform "os-stop" on instance ".*": failed on purpose`)
+}
+
+func (s *localServerSuite) TestInstancesErrorResponse(c *gc.C) {
+	coretesting.SkipIfPPC64EL(c, "lp:1425242")
+
+	env := s.Prepare(c)
+	cleanup := s.srv.Nova.RegisterControlPoint(
+		"server",
+		func(sc hook.ServiceControl, args ...interface{}) error {
+			return fmt.Errorf("strange error not instance")
+		},
+	)
+	defer cleanup()
+
+	instances, err := env.Instances([]instance.Id{"1"})
+	c.Check(instances, gc.IsNil)
 	c.Assert(err, gc.ErrorMatches, "(?s).*strange error not instance.*")
 }
 
@@ -948,6 +1612,68 @@ func (s *localServerSuite) TestFindImageInvalidInstanceConstraint(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `no instance types in some-region matching constraints "instance-type=m1.large"`)
 }
 
+func (s *localServerSuite) TestFindImageExcludePattern(c *gc.C) {
+	// Prevent falling over to the public datasource.
+	s.BaseSuite.PatchValue(&imagemetadata.DefaultBaseURL, "")
+
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"image-exclude-pattern": "1",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	spec, err := openstack.FindInstanceSpec(env, coretesting.FakeDefaultSeries, "amd64", "")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(spec.Image.Id, gc.Equals, "3")
+}
+
+func (s *localServerSuite) TestFindImageExcludePatternExcludesAll(c *gc.C) {
+	// Prevent falling over to the public datasource.
+	s.BaseSuite.PatchValue(&imagemetadata.DefaultBaseURL, "")
+
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"image-exclude-pattern": "1|3",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = openstack.FindInstanceSpec(env, coretesting.FakeDefaultSeries, "amd64", "")
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *localServerSuite) findImageWithRequiredProperties(c *gc.C) (*instances.InstanceSpec, error) {
+	// Prevent falling over to the public datasource.
+	s.BaseSuite.PatchValue(&imagemetadata.DefaultBaseURL, "")
+
+	cfg, err := config.New(config.NoDefaults, s.TestConfig.Merge(coretesting.Attrs{
+		"image-required-properties": "hardened=true",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	return openstack.FindInstanceSpec(env, coretesting.FakeDefaultSeries, "amd64", "")
+}
+
+func (s *localServerSuite) TestFindImageRequiredProperties(c *gc.C) {
+	s.PatchValue(openstack.NovaImageProperties, func(_ client.Client, imageId string) (map[string]string, error) {
+		if imageId == "3" {
+			return map[string]string{"hardened": "true"}, nil
+		}
+		return map[string]string{"hardened": "false"}, nil
+	})
+	spec, err := s.findImageWithRequiredProperties(c)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(spec.Image.Id, gc.Equals, "3")
+}
+
+func (s *localServerSuite) TestFindImageRequiredPropertiesNoneMatch(c *gc.C) {
+	s.PatchValue(openstack.NovaImageProperties, func(client.Client, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	})
+	_, err := s.findImageWithRequiredProperties(c)
+	c.Assert(err, gc.NotNil)
+}
+
 func (s *localServerSuite) TestPrecheckInstanceValidInstanceType(c *gc.C) {
 	env := s.Open(c)
 	cons := constraints.MustParse("instance-type=m1.small")
@@ -985,6 +1711,23 @@ func (t *localServerSuite) TestPrecheckInstanceAvailZoneUnknown(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `invalid availability zone "test-unknown"`)
 }
 
+func (t *localServerSuite) TestPrecheckInstanceAvailZoneDrained(c *gc.C) {
+	env := t.Prepare(c)
+	t.drainZones(c, env, "test-available")
+	placement := "zone=test-available"
+	err := env.PrecheckInstance(coretesting.FakeDefaultSeries, constraints.Value{}, placement)
+	c.Assert(err, gc.ErrorMatches, `availability zone "test-available" is drained`)
+}
+
+func (t *localServerSuite) drainZones(c *gc.C, env environs.Environ, zones string) {
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"drained-availability-zones": zones,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
 func (t *localServerSuite) TestPrecheckInstanceAvailZonesUnsupported(c *gc.C) {
 	t.srv.Nova.SetAvailabilityZones() // no availability zone support
 	env := t.Prepare(c)
@@ -1005,6 +1748,27 @@ func (s *localServerSuite) TestValidateImageMetadata(c *gc.C) {
 	c.Assert(image_ids, jc.SameContents, []string{"id-y"})
 }
 
+func (s *localServerSuite) TestMetadataLookupParamsForSeries(c *gc.C) {
+	env := s.Open(c)
+	params, err := openstack.MetadataLookupParamsForSeries(env, "some-region", "raring", []string{"amd64"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(params.Series, gc.Equals, "raring")
+	c.Assert(params.Architectures, jc.DeepEquals, []string{"amd64"})
+	params.Sources, err = environs.ImageMetadataSources(env)
+	c.Assert(err, jc.ErrorIsNil)
+	image_ids, _, err := imagemetadata.ValidateImageMetadata(params)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(image_ids, jc.SameContents, []string{"id-y"})
+}
+
+func (s *localServerSuite) TestMetadataLookupParamsDefaults(c *gc.C) {
+	env := s.Open(c)
+	params, err := env.(simplestreams.MetadataValidator).MetadataLookupParams("some-region")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(params.Series, gc.Equals, config.PreferredSeries(env.Config()))
+	c.Assert(params.Architectures, jc.DeepEquals, arch.AllSupportedArches)
+}
+
 func (s *localServerSuite) TestImageMetadataSourceOrder(c *gc.C) {
 	src := func(env environs.Environ) (simplestreams.DataSource, error) {
 		return simplestreams.NewURLDataSource("my datasource", "bar", false), nil
@@ -1380,6 +2144,108 @@ func (s *localServerSuite) TestAllInstancesIgnoresOtherMachines(c *gc.C) {
 	c.Check(insts, gc.HasLen, 1)
 }
 
+func (s *localServerSuite) startStaleServer(c *gc.C, env environs.Environ, name string) {
+	_, err := openstack.GetNovaClient(env).RunServer(nova.RunServerOpts{
+		Name:     name,
+		FlavorId: "1",
+		ImageId:  "1",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+// setInstanceNameCollision sets the environment's
+// instance-name-collision.
+func setInstanceNameCollision(c *gc.C, env environs.Environ, value string) {
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"instance-name-collision": value,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *localServerSuite) TestStartInstanceNameCollisionAllowed(c *gc.C) {
+	env := s.Prepare(c)
+	name := fmt.Sprintf("juju-%s-machine-1", env.Config().Name())
+	s.startStaleServer(c, env, name)
+
+	// By default the name is reused, as Nova permits.
+	inst, _ := testing.AssertStartInstance(c, env, "1")
+	c.Assert(openstack.InstanceServerDetail(inst).Name, gc.Equals, name)
+}
+
+func (s *localServerSuite) TestStartInstanceNameCollision(c *gc.C) {
+	env := s.Prepare(c)
+	setInstanceNameCollision(c, env, "error")
+	name := fmt.Sprintf("juju-%s-machine-1", env.Config().Name())
+	s.startStaleServer(c, env, name)
+
+	_, _, _, err := testing.StartInstance(env, "1")
+	c.Assert(err, gc.ErrorMatches, fmt.Sprintf(`a server named %q already exists, .*`, name))
+}
+
+func (s *localServerSuite) TestStartInstanceNameCollisionSuffix(c *gc.C) {
+	env := s.Prepare(c)
+	setInstanceNameCollision(c, env, "suffix")
+	name := fmt.Sprintf("juju-%s-machine-1", env.Config().Name())
+	s.startStaleServer(c, env, name)
+	s.startStaleServer(c, env, name+"-1")
+
+	inst, _ := testing.AssertStartInstance(c, env, "1")
+	c.Assert(openstack.InstanceServerDetail(inst).Name, gc.Equals, name+"-2")
+}
+
+func (s *localServerSuite) TestAllInstancesListedInChunks(c *gc.C) {
+	env := s.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"list-servers-concurrency": 3,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+
+	novaClient := openstack.GetNovaClient(env)
+	const numServers = 25
+	for i := 0; i < numServers; i++ {
+		_, err := novaClient.RunServer(nova.RunServerOpts{
+			Name:     fmt.Sprintf("juju-%s-machine-%d", env.Config().Name(), i),
+			FlavorId: "1",
+			ImageId:  "1",
+		})
+		c.Assert(err, jc.ErrorIsNil)
+	}
+
+	var mu sync.Mutex
+	var calls, inProgress, maxInProgress int
+	listServersDetail := *openstack.NovaListServersDetail
+	s.PatchValue(openstack.NovaListServersDetail, func(nc *nova.Client, filter *nova.Filter) ([]nova.ServerDetail, error) {
+		mu.Lock()
+		calls++
+		inProgress++
+		if inProgress > maxInProgress {
+			maxInProgress = inProgress
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inProgress--
+			mu.Unlock()
+		}()
+		return listServersDetail(nc, filter)
+	})
+
+	insts, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, numServers)
+	ids := make(map[instance.Id]bool)
+	for _, inst := range insts {
+		ids[inst.Id()] = true
+	}
+	c.Assert(ids, gc.HasLen, numServers)
+	c.Assert(calls, gc.Equals, 10)
+	c.Assert(maxInProgress <= 3, jc.IsTrue)
+}
+
 func (s *localServerSuite) TestResolveNetworkUUID(c *gc.C) {
 	env := s.Prepare(c)
 	var sampleUUID = "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"
@@ -1424,43 +2290,277 @@ func (t *localServerSuite) TestStartInstanceAvailZoneUnknown(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `invalid availability zone "test-unknown"`)
 }
 
-func (t *localServerSuite) testStartInstanceAvailZone(c *gc.C, zone string) (instance.Instance, error) {
+func (t *localServerSuite) TestBootstrapAvailZone(c *gc.C) {
+	t.srv.Nova.SetAvailabilityZones(
+		nova.AvailabilityZone{
+			Name: "az1",
+			State: nova.AvailabilityZoneState{
+				Available: true,
+			},
+		},
+		nova.AvailabilityZone{
+			Name: "az2",
+			State: nova.AvailabilityZoneState{
+				Available: true,
+			},
+		},
+	)
 	env := t.Prepare(c)
-	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		Placement: "zone=az1",
+	})
 	c.Assert(err, jc.ErrorIsNil)
 
-	params := environs.StartInstanceParams{Placement: "zone=" + zone}
-	result, err := testing.StartInstanceWithParams(env, "1", params, nil)
-	if err != nil {
-		return nil, err
-	}
-	return result.Instance, nil
+	ids, err := env.StateServerInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(ids, gc.HasLen, 1)
+	insts, err := env.Instances(ids)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(openstack.InstanceServerDetail(insts[0]).AvailabilityZone, gc.Equals, "az1")
 }
 
-func (t *localServerSuite) TestGetAvailabilityZones(c *gc.C) {
-	var resultZones []nova.AvailabilityZone
-	var resultErr error
-	t.PatchValue(openstack.NovaListAvailabilityZones, func(c *nova.Client) ([]nova.AvailabilityZone, error) {
-		return append([]nova.AvailabilityZone{}, resultZones...), resultErr
-	})
-	env := t.Prepare(c).(common.ZonedEnviron)
+func (s *localServerSuite) TestStateServerInstancesEventualConsistency(c *gc.C) {
+	env := s.Prepare(c)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
 
-	resultErr = fmt.Errorf("failed to get availability zones")
-	zones, err := env.AvailabilityZones()
-	c.Assert(err, gc.Equals, resultErr)
-	c.Assert(zones, gc.IsNil)
+	// Hide the state server metadata from the first listing, as if
+	// it had not yet propagated.
+	var calls int
+	listServersDetail := *openstack.NovaListServersDetail
+	s.PatchValue(openstack.NovaListServersDetail, func(nc *nova.Client, filter *nova.Filter) ([]nova.ServerDetail, error) {
+		servers, err := listServersDetail(nc, filter)
+		calls++
+		if calls == 1 {
+			for i := range servers {
+				servers[i].Metadata = nil
+			}
+		}
+		return servers, err
+	})
 
-	resultErr = nil
-	resultZones = make([]nova.AvailabilityZone, 1)
-	resultZones[0].Name = "whatever"
-	zones, err = env.AvailabilityZones()
+	ids, err := env.StateServerInstances()
 	c.Assert(err, jc.ErrorIsNil)
-	c.Assert(zones, gc.HasLen, 1)
-	c.Assert(zones[0].Name(), gc.Equals, "whatever")
+	c.Assert(ids, gc.HasLen, 1)
+	c.Assert(calls, gc.Equals, 2)
+}
 
-	// A successful result is cached, currently for the lifetime
-	// of the Environ. This will change if/when we have long-lived
-	// Environs to cut down repeated IaaS requests.
+func (s *localServerSuite) TestStateServerInstancesNotBootstrapped(c *gc.C) {
+	env := s.Prepare(c)
+	var calls int
+	listServersDetail := *openstack.NovaListServersDetail
+	s.PatchValue(openstack.NovaListServersDetail, func(nc *nova.Client, filter *nova.Filter) ([]nova.ServerDetail, error) {
+		calls++
+		return listServersDetail(nc, filter)
+	})
+
+	// With no instances at all there is nothing to wait for.
+	_, err := env.StateServerInstances()
+	c.Assert(err, gc.Equals, environs.ErrNoInstances)
+	c.Assert(calls, gc.Equals, 1)
+}
+
+func (t *localServerSuite) TestBootstrapAvailZoneUnavailable(c *gc.C) {
+	cleanup := t.srv.Nova.RegisterControlPoint(
+		"addServer",
+		func(sc hook.ServiceControl, args ...interface{}) error {
+			return fmt.Errorf("no instance should have been provisioned")
+		},
+	)
+	defer cleanup()
+	env := t.Prepare(c)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		Placement: "zone=test-unavailable",
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot bootstrap in requested placement: availability zone "test-unavailable" is unavailable`)
+}
+
+func (t *localServerSuite) TestBootstrapAdoptExistingInstance(c *gc.C) {
+	env := t.Prepare(c)
+	existing, _ := testing.AssertStartInstance(c, env, "100")
+
+	var renamed []string
+	t.PatchValue(openstack.NovaRenameServer, func(_ client.Client, serverId, name string) error {
+		renamed = append(renamed, serverId, name)
+		return nil
+	})
+	cleanup := t.srv.Nova.RegisterControlPoint(
+		"addServer",
+		func(sc hook.ServiceControl, args ...interface{}) error {
+			return fmt.Errorf("no instance should have been provisioned")
+		},
+	)
+	defer cleanup()
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		Placement:   "instance=" + string(existing.Id()),
+		Constraints: constraints.MustParse("arch=amd64"),
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(renamed, jc.DeepEquals, []string{
+		string(existing.Id()),
+		"juju-" + env.Config().Name() + "-machine-0",
+	})
+	server, err := openstack.GetNovaClient(env).GetServer(string(existing.Id()))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(server.Metadata[tags.JujuStateServer], gc.Equals, "true")
+}
+
+func (t *localServerSuite) TestStopAdoptedInstanceReleasesIt(c *gc.C) {
+	env := t.Prepare(c)
+	existing, _ := testing.AssertStartInstance(c, env, "100")
+	novaClient := openstack.GetNovaClient(env)
+	server, err := novaClient.GetServer(string(existing.Id()))
+	c.Assert(err, jc.ErrorIsNil)
+	originalName := server.Name
+
+	var renamed []string
+	t.PatchValue(openstack.NovaRenameServer, func(_ client.Client, serverId, name string) error {
+		renamed = append(renamed, name)
+		return nil
+	})
+	var deleted []string
+	t.PatchValue(openstack.NovaDeleteServerMetadata, func(_ client.Client, serverId, key string) error {
+		c.Check(serverId, gc.Equals, string(existing.Id()))
+		deleted = append(deleted, key)
+		return nil
+	})
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		Placement:   "instance=" + string(existing.Id()),
+		Constraints: constraints.MustParse("arch=amd64"),
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	// Stopping the instance, as when bootstrap fails, gives the server
+	// back under its original name rather than deleting it.
+	err = env.StopInstances(existing.Id())
+	c.Assert(err, jc.ErrorIsNil)
+	server, err = novaClient.GetServer(string(existing.Id()))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(renamed, jc.DeepEquals, []string{
+		"juju-" + env.Config().Name() + "-machine-0",
+		originalName,
+	})
+	c.Assert(set.NewStrings(deleted...).Contains(tags.JujuStateServer), jc.IsTrue)
+	c.Assert(deleted[len(deleted)-1], gc.Equals, "juju-adopted-name")
+	groups, err := novaClient.GetServerSecurityGroups(server.Id)
+	c.Assert(err, jc.ErrorIsNil)
+	for _, group := range groups {
+		c.Check(group.Name, gc.Not(jc.HasPrefix), "juju-"+env.Config().Name())
+	}
+}
+
+func (t *localServerSuite) TestStopInstancesListsServersOnce(c *gc.C) {
+	// With a global firewall, StopInstances lists no servers of its own.
+	cfg, err := config.New(config.NoDefaults, t.TestConfig.Merge(coretesting.Attrs{
+		"firewall-mode": config.FwGlobal}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	inst0, _ := testing.AssertStartInstance(c, env, "100")
+	inst1, _ := testing.AssertStartInstance(c, env, "101")
+
+	calls := 0
+	listServersDetail := *openstack.NovaListServersDetail
+	t.PatchValue(openstack.NovaListServersDetail, func(nc *nova.Client, filter *nova.Filter) ([]nova.ServerDetail, error) {
+		calls++
+		return listServersDetail(nc, filter)
+	})
+	err = env.StopInstances(inst0.Id(), inst1.Id())
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, gc.Equals, 1)
+	insts, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 0)
+}
+
+func (t *localServerSuite) TestStopInstancesLookupErrorDeletes(c *gc.C) {
+	// With a global firewall, StopInstances lists no servers of its own.
+	cfg, err := config.New(config.NoDefaults, t.TestConfig.Merge(coretesting.Attrs{
+		"firewall-mode": config.FwGlobal}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	inst0, _ := testing.AssertStartInstance(c, env, "100")
+	inst1, _ := testing.AssertStartInstance(c, env, "101")
+
+	fail := true
+	listServersDetail := *openstack.NovaListServersDetail
+	t.PatchValue(openstack.NovaListServersDetail, func(nc *nova.Client, filter *nova.Filter) ([]nova.ServerDetail, error) {
+		if fail {
+			return nil, fmt.Errorf("failed on purpose")
+		}
+		return listServersDetail(nc, filter)
+	})
+	err = env.StopInstances(inst0.Id(), inst1.Id())
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(c.GetTestLog(), jc.Contains, "cannot look up instances to terminate: failed on purpose")
+
+	fail = false
+	insts, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(insts, gc.HasLen, 0)
+}
+
+func (t *localServerSuite) TestBootstrapAdoptUnknownInstance(c *gc.C) {
+	cleanup := t.srv.Nova.RegisterControlPoint(
+		"addServer",
+		func(sc hook.ServiceControl, args ...interface{}) error {
+			return fmt.Errorf("no instance should have been provisioned")
+		},
+	)
+	defer cleanup()
+	env := t.Prepare(c)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{
+		Placement: "instance=no-such-instance",
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot bootstrap in requested placement: instance "no-such-instance" not found`)
+}
+
+func (t *localServerSuite) TestStartInstanceAdoptNotBootstrap(c *gc.C) {
+	env := t.Prepare(c)
+	existing, _ := testing.AssertStartInstance(c, env, "100")
+	params := environs.StartInstanceParams{Placement: "instance=" + string(existing.Id())}
+	_, err := testing.StartInstanceWithParams(env, "1", params, nil)
+	c.Assert(err, jc.Satisfies, jujuerrors.IsNotSupported)
+}
+
+func (t *localServerSuite) testStartInstanceAvailZone(c *gc.C, zone string) (instance.Instance, error) {
+	env := t.Prepare(c)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	params := environs.StartInstanceParams{Placement: "zone=" + zone}
+	result, err := testing.StartInstanceWithParams(env, "1", params, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result.Instance, nil
+}
+
+func (t *localServerSuite) TestGetAvailabilityZones(c *gc.C) {
+	var resultZones []nova.AvailabilityZone
+	var resultErr error
+	t.PatchValue(openstack.NovaListAvailabilityZones, func(c *nova.Client) ([]nova.AvailabilityZone, error) {
+		return append([]nova.AvailabilityZone{}, resultZones...), resultErr
+	})
+	env := t.Prepare(c).(common.ZonedEnviron)
+
+	resultErr = fmt.Errorf("failed to get availability zones")
+	zones, err := env.AvailabilityZones()
+	c.Assert(err, gc.Equals, resultErr)
+	c.Assert(zones, gc.IsNil)
+
+	resultErr = nil
+	resultZones = make([]nova.AvailabilityZone, 1)
+	resultZones[0].Name = "whatever"
+	zones, err = env.AvailabilityZones()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(zones, gc.HasLen, 1)
+	c.Assert(zones[0].Name(), gc.Equals, "whatever")
+
+	// A successful result is cached, currently for the lifetime
+	// of the Environ. This will change if/when we have long-lived
+	// Environs to cut down repeated IaaS requests.
 	resultErr = fmt.Errorf("failed to get availability zones")
 	resultZones[0].Name = "andever"
 	zones, err = env.AvailabilityZones()
@@ -1560,6 +2660,127 @@ func (t *localServerSuite) TestStartInstanceDistribution(c *gc.C) {
 	c.Assert(openstack.InstanceServerDetail(inst).AvailabilityZone, gc.Equals, "test-available")
 }
 
+func (t *localServerSuite) setTwoAvailabilityZones() {
+	t.srv.Nova.SetAvailabilityZones(
+		nova.AvailabilityZone{
+			Name: "az1",
+			State: nova.AvailabilityZoneState{
+				Available: true,
+			},
+		},
+		nova.AvailabilityZone{
+			Name: "az2",
+			State: nova.AvailabilityZoneState{
+				Available: true,
+			},
+		},
+	)
+}
+
+func (t *localServerSuite) TestStartInstanceSkipsDrainedZones(c *gc.C) {
+	t.setTwoAvailabilityZones()
+	env := t.Prepare(c)
+	t.drainZones(c, env, "az1")
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	// az1 is the least populated zone, but it is drained.
+	inst, _ := testing.AssertStartInstance(c, env, "1")
+	c.Assert(openstack.InstanceServerDetail(inst).AvailabilityZone, gc.Equals, "az2")
+}
+
+func (t *localServerSuite) TestStartInstanceAllZonesDrained(c *gc.C) {
+	t.setTwoAvailabilityZones()
+	env := t.Prepare(c)
+	t.drainZones(c, env, "az1, az2")
+	_, _, _, err := testing.StartInstance(env, "1")
+	c.Assert(err, gc.ErrorMatches, "all availability zones are drained")
+}
+
+func (t *localServerSuite) setThreeAvailabilityZones() {
+	var zones []nova.AvailabilityZone
+	for _, name := range []string{"az1", "az2", "az3"} {
+		zones = append(zones, nova.AvailabilityZone{
+			Name:  name,
+			State: nova.AvailabilityZoneState{Available: true},
+		})
+	}
+	t.srv.Nova.SetAvailabilityZones(zones...)
+}
+
+func (t *localServerSuite) TestStateServerZones(c *gc.C) {
+	t.setThreeAvailabilityZones()
+	env := t.Prepare(c)
+	zones, err := openstack.StateServerZones(env, 5)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(zones, jc.DeepEquals, []string{"az1", "az2", "az3", "az1", "az2"})
+}
+
+func (t *localServerSuite) TestStateServerZonesAvoidsExistingStateServers(c *gc.C) {
+	t.setThreeAvailabilityZones()
+	env := t.Prepare(c)
+	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+	ids, err := env.StateServerInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	insts, err := env.Instances(ids)
+	c.Assert(err, jc.ErrorIsNil)
+	bootstrapZone := openstack.InstanceServerDetail(insts[0]).AvailabilityZone
+
+	zones, err := openstack.StateServerZones(env, 2)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(zones, gc.HasLen, 2)
+	c.Assert(zones[0], gc.Not(gc.Equals), zones[1])
+	for _, zone := range zones {
+		c.Assert(zone, gc.Not(gc.Equals), bootstrapZone)
+	}
+}
+
+func (t *localServerSuite) TestStateServerZonesPinned(c *gc.C) {
+	t.setThreeAvailabilityZones()
+	env := t.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"state-server-zones": "az3,az2",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	zones, err := openstack.StateServerZones(env, 3)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(zones, jc.DeepEquals, []string{"az2", "az3", "az2"})
+}
+
+func (t *localServerSuite) TestBootstrapUsesStateServerZones(c *gc.C) {
+	t.setThreeAvailabilityZones()
+	env := t.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"state-server-zones": "az3",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+	ids, err := env.StateServerInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	insts, err := env.Instances(ids)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(openstack.InstanceServerDetail(insts[0]).AvailabilityZone, gc.Equals, "az3")
+}
+
+func (t *localServerSuite) TestStateServerZonesPinnedUnknown(c *gc.C) {
+	t.setThreeAvailabilityZones()
+	env := t.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"state-server-zones": "az1,az9",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = openstack.StateServerZones(env, 3)
+	c.Assert(err, gc.ErrorMatches, `state-server-zones: availability zone "az9" is not available`)
+}
+
 func (t *localServerSuite) TestStartInstancePicksValidZoneForHost(c *gc.C) {
 	coretesting.SkipIfPPC64EL(c, "lp:1425242")
 
@@ -1645,6 +2866,58 @@ func (t *localServerSuite) TestStartInstanceWithUnknownAZError(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, "(?s).*Some unknown error.*")
 }
 
+func (t *localServerSuite) TestStartInstanceNoValidHostWithoutZoneRetry(c *gc.C) {
+	coretesting.SkipIfPPC64EL(c, "lp:1425242")
+
+	t.srv.Nova.SetAvailabilityZones(
+		// bootstrap node will be on az1.
+		nova.AvailabilityZone{
+			Name: "az1",
+			State: nova.AvailabilityZoneState{
+				Available: true,
+			},
+		},
+		// az2 will be made to return an error.
+		nova.AvailabilityZone{
+			Name: "az2",
+			State: nova.AvailabilityZoneState{
+				Available: true,
+			},
+		},
+		// az3 would be valid to host an instance, but is not tried.
+		nova.AvailabilityZone{
+			Name: "az3",
+			State: nova.AvailabilityZoneState{
+				Available: true,
+			},
+		},
+	)
+
+	env := t.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"retry-zones-on-no-valid-host": false,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	cleanup := t.srv.Nova.RegisterControlPoint(
+		"addServer",
+		func(sc hook.ServiceControl, args ...interface{}) error {
+			serverDetail := args[0].(*nova.ServerDetail)
+			if serverDetail.AvailabilityZone == "az2" {
+				return fmt.Errorf("No valid host was found")
+			}
+			return nil
+		},
+	)
+	defer cleanup()
+	_, _, _, err = testing.StartInstance(env, "1")
+	c.Assert(err, gc.ErrorMatches, "(?s).*No valid host was found.*")
+}
+
 func (t *localServerSuite) TestStartInstanceDistributionAZNotImplemented(c *gc.C) {
 	env := t.Prepare(c)
 	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
@@ -1679,6 +2952,247 @@ func (t *localServerSuite) TestInstanceTags(c *gc.C) {
 	)
 }
 
+func (t *localServerSuite) TestInstanceTagsMetadataKeyPrefix(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, t.TestConfig.Merge(coretesting.Attrs{
+		"metadata-key-prefix": "acme-",
+		"resource-tags":       "cost-center=1234",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	instances, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(instances, gc.HasLen, 1)
+	c.Assert(
+		openstack.InstanceServerDetail(instances[0]).Metadata,
+		jc.DeepEquals,
+		map[string]string{
+			"acme-env-uuid": coretesting.EnvironmentTag.Id(),
+			"acme-is-state": "true",
+			"cost-center":   "1234",
+		},
+	)
+	// The state server is found by its prefixed metadata.
+	ids, err := env.StateServerInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(ids, jc.DeepEquals, []instance.Id{instances[0].Id()})
+}
+
+type flavorChoiceExplainer interface {
+	ExplainFlavorChoice(constraints.Value) (*openstack.FlavorChoice, error)
+}
+
+func explainFlavorChoice(c *gc.C, env environs.Environ, cons string) (*openstack.FlavorChoice, map[string]string) {
+	choice, err := env.(flavorChoiceExplainer).ExplainFlavorChoice(constraints.MustParse(cons))
+	c.Assert(err, jc.ErrorIsNil)
+	rejected := make(map[string]string)
+	for _, candidate := range choice.Candidates {
+		rejected[candidate.Name] = candidate.Rejected
+	}
+	return choice, rejected
+}
+
+func (t *localServerSuite) TestExplainFlavorChoice(c *gc.C) {
+	env := t.Prepare(c)
+	choice, rejected := explainFlavorChoice(c, env, "mem=1024")
+	c.Assert(choice.Selected, gc.Equals, "m1.small")
+	c.Assert(choice.Reason, gc.Equals, "it is the smallest flavor satisfying the constraints")
+	c.Assert(choice.Candidates[0].Name, gc.Equals, "m1.small")
+	c.Assert(rejected["m1.small"], gc.Equals, "")
+	c.Assert(rejected["m1.tiny"], gc.Equals, "has 512M memory, less than 1024M")
+}
+
+func (t *localServerSuite) TestExplainFlavorChoiceInstanceType(c *gc.C) {
+	env := t.Prepare(c)
+	choice, rejected := explainFlavorChoice(c, env, "instance-type=m1.tiny")
+	c.Assert(choice.Selected, gc.Equals, "m1.tiny")
+	c.Assert(choice.Reason, gc.Equals, "it is the only flavor satisfying the constraints")
+	c.Assert(rejected["m1.tiny"], gc.Equals, "")
+	c.Assert(rejected["m1.small"], gc.Equals, `not the requested instance-type "m1.tiny"`)
+}
+
+func (t *localServerSuite) TestExplainFlavorChoiceDefaultMemory(c *gc.C) {
+	env := t.Prepare(c)
+	choice, rejected := explainFlavorChoice(c, env, "")
+	c.Assert(choice.Selected, gc.Equals, "m1.small")
+	c.Assert(rejected["m1.tiny"], gc.Matches, "not preferred: .*")
+}
+
+func (t *localServerSuite) TestExplainFlavorChoiceNoMatch(c *gc.C) {
+	env := t.Prepare(c)
+	choice, rejected := explainFlavorChoice(c, env, "mem=1T")
+	c.Assert(choice.Selected, gc.Equals, "")
+	c.Assert(choice.Reason, gc.Equals, `no flavor satisfies constraints "mem=1048576M"`)
+	for name, reason := range rejected {
+		c.Check(reason, gc.Matches, "has .* memory, less than 1048576M", gc.Commentf("flavor %s", name))
+	}
+}
+
+func (t *localServerSuite) TestExplainFlavorChoiceTags(c *gc.C) {
+	env := t.Prepare(c)
+	choice, rejected := explainFlavorChoice(c, env, "tags=ssd")
+	c.Assert(choice.Selected, gc.Equals, "")
+	for name, reason := range rejected {
+		c.Check(reason, gc.Equals, `does not have tag "ssd"`, gc.Commentf("flavor %s", name))
+	}
+}
+
+func (t *localServerSuite) TestExplainFlavorChoiceDefaultConstraints(c *gc.C) {
+	env := t.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"default-constraints": "mem=1024",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	choice, rejected := explainFlavorChoice(c, env, "")
+	c.Assert(choice.Selected, gc.Equals, "m1.small")
+	c.Assert(rejected["m1.tiny"], gc.Equals, "has 512M memory, less than 1024M")
+}
+
+func (t *localServerSuite) TestStartInstanceFQDNMetadata(c *gc.C) {
+	env := t.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"instance-fqdn-template": "m{machine}.{env}.example.com",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+
+	inst, _ := testing.AssertStartInstance(c, env, "1")
+	metadata := openstack.InstanceServerDetail(inst).Metadata
+	c.Assert(metadata["juju-fqdn"], gc.Equals, fmt.Sprintf("m1.%s.example.com", env.Config().Name()))
+}
+
+func (t *localServerSuite) setDefaultConstraints(c *gc.C, env environs.Environ, cons string) {
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"default-constraints": cons,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (t *localServerSuite) TestStartInstanceDefaultConstraints(c *gc.C) {
+	env := t.Prepare(c)
+	t.setDefaultConstraints(c, env, "mem=3G")
+
+	_, hc := testing.AssertStartInstance(c, env, "1")
+	c.Check(*hc.Mem >= 3072, jc.IsTrue)
+}
+
+func (t *localServerSuite) TestStartInstanceExplicitConstraintsOverrideDefaults(c *gc.C) {
+	env := t.Prepare(c)
+	t.setDefaultConstraints(c, env, "mem=3G")
+
+	_, hc := testing.AssertStartInstanceWithConstraints(c, env, "1", constraints.MustParse("mem=1024"))
+	c.Check(*hc.Mem, gc.Equals, uint64(2048))
+	_, hc = testing.AssertStartInstanceWithConstraints(c, env, "2", constraints.MustParse("instance-type=m1.small"))
+	c.Check(*hc.Mem, gc.Equals, uint64(2048))
+}
+
+func (t *localServerSuite) TestPrecheckInstanceDefaultConstraints(c *gc.C) {
+	env := t.Prepare(c)
+	t.setDefaultConstraints(c, env, "instance-type=m1.small")
+	err := env.PrecheckInstance(coretesting.FakeDefaultSeries, constraints.Value{}, "")
+	c.Assert(err, jc.ErrorIsNil)
+
+	t.setDefaultConstraints(c, env, "instance-type=m1.large")
+	err = env.PrecheckInstance(coretesting.FakeDefaultSeries, constraints.Value{}, "")
+	c.Assert(err, gc.ErrorMatches, `cannot apply default-constraints: invalid constraint value: instance-type=m1.large\n.*`)
+}
+
+func (t *localServerSuite) TestInstanceResourceTags(c *gc.C) {
+	env := t.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"resource-tags": "cost-center=1234",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	instances, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(instances, gc.HasLen, 1)
+	c.Assert(
+		openstack.InstanceServerDetail(instances[0]).Metadata,
+		jc.DeepEquals,
+		map[string]string{
+			"juju-env-uuid": coretesting.EnvironmentTag.Id(),
+			"juju-is-state": "true",
+			"cost-center":   "1234",
+		},
+	)
+}
+
+func (t *localServerSuite) TestStartInstanceTooManyMetadataEntries(c *gc.C) {
+	t.PatchValue(openstack.NovaMaxServerMeta, func(client.Client) (int, error) {
+		return 3, nil
+	})
+	env := t.Prepare(c)
+	cfg, err := env.Config().Apply(map[string]interface{}{
+		"resource-tags": "cost-center=1234 owner=ops",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = env.SetConfig(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, gc.ErrorMatches, `.*too many metadata entries: 4 exceeds the limit of 3; drop 1 of the resource tags cost-center, owner`)
+}
+
+func (t *localServerSuite) TestStartInstanceImageStreamFallback(c *gc.C) {
+	// The test image metadata has no daily stream, so the image
+	// must be found in the released stream.
+	t.PatchValue(&imagemetadata.DefaultBaseURL, "")
+	cfg, err := config.New(config.NoDefaults, t.TestConfig.Merge(coretesting.Attrs{
+		"image-streams": "daily, released",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	instances, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(instances, gc.HasLen, 1)
+}
+
+func (t *localServerSuite) TestStartInstanceNoImageInImageStreams(c *gc.C) {
+	t.PatchValue(&imagemetadata.DefaultBaseURL, "")
+	cfg, err := config.New(config.NoDefaults, t.TestConfig.Merge(coretesting.Attrs{
+		"image-streams": "daily",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, gc.NotNil)
+}
+
+func (t *localServerSuite) TestInstanceNameTemplate(c *gc.C) {
+	cfg, err := config.New(config.NoDefaults, t.TestConfig.Merge(coretesting.Attrs{
+		"instance-name-template": "{env}-node-{machine}",
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	env, err := environs.New(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The instance is found by its tags rather than its name.
+	instances, err := env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(instances, gc.HasLen, 1)
+	c.Assert(openstack.InstanceServerDetail(instances[0]).Name, gc.Equals, env.Config().Name()+"-node-0")
+}
+
 func (t *localServerSuite) TestTagInstance(c *gc.C) {
 	env := t.Prepare(c)
 	err := bootstrap.Bootstrap(envtesting.BootstrapContext(c), env, bootstrap.BootstrapParams{})
@@ -6,9 +6,12 @@
 package openstack
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"sync"
@@ -18,8 +21,10 @@ import (
 	"github.com/juju/loggo"
 	"github.com/juju/names"
 	"github.com/juju/utils"
+	"github.com/juju/utils/set"
 	"gopkg.in/goose.v1/client"
 	gooseerrors "gopkg.in/goose.v1/errors"
+	goosehttp "gopkg.in/goose.v1/http"
 	"gopkg.in/goose.v1/identity"
 	"gopkg.in/goose.v1/nova"
 	"gopkg.in/goose.v1/swift"
@@ -39,6 +44,7 @@ import (
 	"github.com/juju/juju/network"
 	"github.com/juju/juju/provider/common"
 	"github.com/juju/juju/state"
+	"github.com/juju/juju/state/multiwatcher"
 	"github.com/juju/juju/tools"
 )
 
@@ -75,11 +81,137 @@ openstack:
     #
     # use-floating-ip: false
 
+    # floating-ip-concurrency sets how many floating IP addresses may
+    # be allocated at once when use-floating-ip is true. Raising it
+    # starts many machines faster, but sends more concurrent requests
+    # to the cloud and so may hit its API rate limits.
+    #
+    # floating-ip-concurrency: 1
+
+    # reuse-floating-ips specifies whether floating IP addresses that
+    # are allocated but not in use are given to new machines before
+    # new addresses are allocated.
+    #
+    # reuse-floating-ips: true
+
+    # default-constraints are applied to every new machine in the
+    # environment, such as a minimum root disk size. Constraints given
+    # for a machine when it is added take precedence.
+    #
+    # default-constraints: root-disk=20G
+
+    # show-unhealthy-servers specifies whether the environment's
+    # servers that are neither running nor stopped, such as those in
+    # the ERROR state, are listed as instances so they can be seen
+    # and removed. Their status is marked as unhealthy.
+    #
+    # show-unhealthy-servers: false
+
+    # list-servers-concurrency sets how many requests are used at once
+    # to list the environment's machines. If it is more than 1, the
+    # listing is split into ten smaller requests, which helps when
+    # there are very many machines.
+    #
+    # list-servers-concurrency: 1
+
+    # instance-fqdn-template is a template for a fully qualified
+    # domain name that is set in the juju-fqdn metadata of each new
+    # machine, for external DNS tools to use. {env} and {machine} are
+    # replaced by the environment name and machine id.
+    #
+    # instance-fqdn-template: {machine}.{env}.example.com
+
+    # retry-zones-on-no-valid-host specifies whether a "No valid host"
+    # error when starting an instance causes the next availability
+    # zone to be tried. On clouds where the error does not depend on
+    # the zone, set it to false so that the failure is reported
+    # straight away.
+    #
+    # retry-zones-on-no-valid-host: true
+
+    # network-reachability-check sets what bootstrap does when
+    # use-floating-ip is false and the instance would only have
+    # addresses on private networks: warn, error or none.
+    #
+    # network-reachability-check: warn
+
+    # image-exclude-pattern holds a regular expression matching the
+    # ids of images that must never be used for new machines, such as
+    # test images that also appear in the image metadata.
+    #
+    # image-exclude-pattern: <regular expression>
+
+    # image-required-properties holds the Glance image properties
+    # that an image must have to be used for new machines, as a
+    # space-separated list of key=value pairs.
+    #
+    # image-required-properties: hardened=true
+
+    # instances-poll-delay, instances-poll-max-delay and
+    # instances-poll-total control how Juju waits for new or removed
+    # instances to be listed by the cloud. The delay between retries
+    # doubles from instances-poll-delay up to instances-poll-max-delay
+    # until instances-poll-total has passed. Raise
+    # instances-poll-total on clouds that are slow to show changes.
+    #
+    # instances-poll-delay: 200ms
+    # instances-poll-max-delay: 1.6s
+    # instances-poll-total: 15s
+
+    # cinder-timeout sets how long to wait for Cinder volumes to be
+    # created, attached or deleted, and cinder-poll-interval how
+    # often they are checked meanwhile. Raise cinder-timeout for slow
+    # storage backends.
+    #
+    # cinder-timeout: 1m
+    # cinder-poll-interval: 5s
+
+    # drained-availability-zones holds a comma-separated list of
+    # availability zones in which no new machines are started, such
+    # as zones whose hypervisors are under maintenance.
+    #
+    # drained-availability-zones: <your zones>
+
+    # state-server-zones holds a comma-separated list of the
+    # availability zones that state servers are spread across. If it
+    # is empty, all available zones that are not drained are used.
+    #
+    # state-server-zones: <your zones>
+
+    # instance-name-collision sets what happens when a server already
+    # has the name chosen for a new machine, as after an incomplete
+    # teardown: allow starts the machine with the same name, error
+    # refuses to start it, and suffix adds a number to the name to
+    # make it unique.
+    #
+    # instance-name-collision: allow
+
+    # destroy-mode sets what destroying the environment does to its
+    # instances: terminate deletes them, and stop shuts them off,
+    # detaches their volumes and releases their floating IPs, keeping
+    # the instances, volumes and security groups until the environment
+    # is destroyed again.
+    #
+    # destroy-mode: terminate
+
     # use-default-secgroup specifies whether new machine instances
     # should have the "default" Openstack security group assigned.
     #
     # use-default-secgroup: false
 
+    # security-groups holds a comma-separated list of existing
+    # Openstack security groups that new machine instances are
+    # added to. Juju never creates or deletes these groups.
+    #
+    # security-groups: <your security groups>
+
+    # manage-security-groups specifies whether Juju creates and
+    # manages its own security groups. If false, instances are added
+    # only to the groups in security-groups and firewall-mode must be
+    # none.
+    #
+    # manage-security-groups: true
+
     # network specifies the network label or uuid to bring machines up
     # on, in the case where multiple networks exist. It may be omitted
     # otherwise.
@@ -125,6 +257,11 @@ openstack:
     #
     # region: <your region>
 
+    # If the environment variable OS_CLIENT_CONFIG_FILE names a
+    # clouds.yaml file, the auth-url, tenant-name, region, username
+    # and password defaults are instead read from the cloud entry
+    # named by OS_CLOUD, together with any secure.yaml file alongside.
+
     # The auth-mode, username and password attributes are used for
     # userpass authentication (the default).
     #
@@ -176,6 +313,19 @@ hpcloud:
     #
     # use-default-secgroup: false
 
+    # security-groups holds a comma-separated list of existing
+    # Openstack security groups that new machine instances are
+    # added to. Juju never creates or deletes these groups.
+    #
+    # security-groups: <your security groups>
+
+    # manage-security-groups specifies whether Juju creates and
+    # manages its own security groups. If false, instances are added
+    # only to the groups in security-groups and firewall-mode must be
+    # none.
+    #
+    # manage-security-groups: true
+
     # tenant-name holds the openstack tenant name. In HPCloud, this is
     # synonymous with the project-name It defaults to the environment
     # variable OS_TENANT_NAME.
@@ -257,6 +407,32 @@ func (p environProvider) RestrictedConfigAttributes() []string {
 
 // PrepareForCreateEnvironment is specified in the EnvironProvider interface.
 func (p environProvider) PrepareForCreateEnvironment(cfg *config.Config) (*config.Config, error) {
+	cfg, err := ensureControlBucket(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cfg.StorageDefaultBlockSource(); ok {
+		return cfg, nil
+	}
+	e, err := p.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// A hosted environment may use a different region or
+	// credentials from the state server's, so look for the volume
+	// endpoint as PrepareForBootstrap does.
+	if err := authenticateClient(e.(*environ)); err != nil {
+		return nil, err
+	}
+	if err := e.(*environ).setDefaultBlockSource(); err != nil {
+		return nil, err
+	}
+	return e.Config(), nil
+}
+
+// ensureControlBucket returns the configuration with a unique
+// control-bucket added if it has none.
+func ensureControlBucket(cfg *config.Config) (*config.Config, error) {
 	attrs := cfg.UnknownAttrs()
 	if _, ok := attrs["control-bucket"]; !ok {
 		uuid, err := utils.NewUUID()
@@ -269,7 +445,7 @@ func (p environProvider) PrepareForCreateEnvironment(cfg *config.Config) (*confi
 }
 
 func (p environProvider) PrepareForBootstrap(ctx environs.BootstrapContext, cfg *config.Config) (environs.Environ, error) {
-	cfg, err := p.PrepareForCreateEnvironment(cfg)
+	cfg, err := ensureControlBucket(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -281,9 +457,47 @@ func (p environProvider) PrepareForBootstrap(ctx environs.BootstrapContext, cfg
 	if err := authenticateClient(e.(*environ)); err != nil {
 		return nil, err
 	}
+	if err := e.(*environ).setDefaultBlockSource(); err != nil {
+		return nil, err
+	}
+	if err := e.(*environ).checkNetworkReachable(); err != nil {
+		return nil, err
+	}
 	return e, nil
 }
 
+// hasVolumeEndpoint reports whether the cloud's service catalog holds
+// a volume endpoint for the environment's region. The client must
+// already be authenticated.
+var hasVolumeEndpoint = func(e *environ) bool {
+	_, ok := e.client.EndpointsForRegion(e.ecfg().region())["volume"]
+	return ok
+}
+
+// setDefaultBlockSource makes cinder the default block storage source
+// if none has been specified and the cloud provides a volume endpoint.
+// Otherwise the default is left unset.
+func (e *environ) setDefaultBlockSource() error {
+	cfg := e.Config()
+	if _, ok := cfg.StorageDefaultBlockSource(); ok {
+		return nil
+	}
+	if !hasVolumeEndpoint(e) {
+		logger.Infof(
+			"no volume endpoint found in region %q; not setting a default block storage source (set %s to choose one)",
+			e.ecfg().region(), config.StorageDefaultBlockSourceKey,
+		)
+		return nil
+	}
+	cfg, err := cfg.Apply(map[string]interface{}{
+		config.StorageDefaultBlockSourceKey: CinderProviderType,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return e.SetConfig(cfg)
+}
+
 // MetadataLookupParams returns parameters which are used to query image metadata to
 // find matching image information.
 func (p environProvider) MetadataLookupParams(region string) (*simplestreams.MetadataLookupParams, error) {
@@ -360,6 +574,17 @@ type environ struct {
 
 	availabilityZonesMutex sync.Mutex
 	availabilityZones      []common.AvailabilityZone
+
+	// reservedFloatingIPs records the ids of floating IPs chosen for
+	// instances that are still being started, so that concurrent
+	// allocations do not choose the same unassigned address.
+	reservedFloatingIPsMutex sync.Mutex
+	reservedFloatingIPs      map[string]bool
+
+	// floatingIPSem limits the number of floating IP allocations in
+	// progress at once to floating-ip-concurrency.
+	floatingIPSemMutex sync.Mutex
+	floatingIPSem      chan struct{}
 }
 
 var _ environs.Environ = (*environ)(nil)
@@ -367,6 +592,7 @@ var _ simplestreams.HasRegion = (*environ)(nil)
 var _ state.Prechecker = (*environ)(nil)
 var _ state.InstanceDistributor = (*environ)(nil)
 var _ environs.InstanceTagger = (*environ)(nil)
+var _ environs.FloatingIPReconciler = (*environ)(nil)
 
 type openstackInstance struct {
 	e        *environ
@@ -407,7 +633,11 @@ func (inst *openstackInstance) Id() instance.Id {
 }
 
 func (inst *openstackInstance) Status() string {
-	return inst.getServerDetail().Status
+	server := inst.getServerDetail()
+	if !inst.e.isAliveServer(*server) {
+		return server.Status + " (unhealthy)"
+	}
+	return server.Status
 }
 
 func (inst *openstackInstance) hardwareCharacteristics() *instance.HardwareCharacteristics {
@@ -654,8 +884,20 @@ func (e *environ) InstanceAvailabilityZoneNames(ids []instance.Id) ([]string, er
 	return zones, err
 }
 
+// isDrainedZone reports whether no new instances may be started in
+// the named availability zone.
+func (e *environ) isDrainedZone(name string) bool {
+	for _, zone := range e.ecfg().drainedAvailabilityZones() {
+		if zone == name {
+			return true
+		}
+	}
+	return false
+}
+
 type openstackPlacement struct {
 	availabilityZone nova.AvailabilityZone
+	fixedIP          string
 }
 
 func (e *environ) parsePlacement(placement string) (*openstackPlacement, error) {
@@ -672,16 +914,43 @@ func (e *environ) parsePlacement(placement string) (*openstackPlacement, error)
 		}
 		for _, z := range zones {
 			if z.Name() == availabilityZone {
+				if e.isDrainedZone(availabilityZone) {
+					return nil, fmt.Errorf("availability zone %q is drained", availabilityZone)
+				}
 				return &openstackPlacement{
 					z.(*openstackAvailabilityZone).AvailabilityZone,
 				}, nil
 			}
 		}
 		return nil, fmt.Errorf("invalid availability zone %q", availabilityZone)
+	case "fixed-ip":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid fixed IP address %q", value)
+		}
+		return &openstackPlacement{fixedIP: ip.String()}, nil
 	}
 	return nil, fmt.Errorf("unknown placement directive: %v", placement)
 }
 
+// placementAvailabilityZone returns the name of the availability zone
+// specified by the placement directive, returning an error if the zone
+// is unknown or unavailable.
+func (e *environ) placementAvailabilityZone(placement string) (string, error) {
+	p, err := e.parsePlacement(placement)
+	if err != nil {
+		return "", err
+	}
+	if p.fixedIP != "" {
+		// Any zone will do.
+		return "", nil
+	}
+	if !p.availabilityZone.State.Available {
+		return "", fmt.Errorf("availability zone %q is unavailable", p.availabilityZone.Name)
+	}
+	return p.availabilityZone.Name, nil
+}
+
 // PrecheckInstance is defined on the state.Prechecker interface.
 func (e *environ) PrecheckInstance(series string, cons constraints.Value, placement string) error {
 	if placement != "" {
@@ -689,6 +958,10 @@ func (e *environ) PrecheckInstance(series string, cons constraints.Value, placem
 			return err
 		}
 	}
+	cons, err := e.withDefaultConstraints(cons)
+	if err != nil {
+		return err
+	}
 	if !cons.HasInstanceType() {
 		return nil
 	}
@@ -706,6 +979,28 @@ func (e *environ) PrecheckInstance(series string, cons constraints.Value, placem
 	return fmt.Errorf("invalid Openstack flavour %q specified", *cons.InstanceType)
 }
 
+// withDefaultConstraints returns the given constraints merged with the
+// environment's default-constraints, which apply only where cons sets
+// neither the same attribute nor a conflicting one.
+func (e *environ) withDefaultConstraints(cons constraints.Value) (constraints.Value, error) {
+	defaults, err := e.ecfg().defaultConstraints()
+	if err != nil {
+		return constraints.Value{}, err
+	}
+	if constraints.IsEmpty(&defaults) {
+		return cons, nil
+	}
+	validator, err := e.ConstraintsValidator()
+	if err != nil {
+		return constraints.Value{}, errors.Trace(err)
+	}
+	merged, err := validator.Merge(defaults, cons)
+	if err != nil {
+		return constraints.Value{}, errors.Annotate(err, "cannot apply default-constraints")
+	}
+	return merged, nil
+}
+
 func (e *environ) Storage() storage.Storage {
 	e.ecfgMutex.Lock()
 	stor := e.storageUnlocked
@@ -720,20 +1015,82 @@ func (e *environ) Bootstrap(ctx environs.BootstrapContext, args environs.Bootstr
 	if err := authenticateClient(e); err != nil {
 		return "", "", nil, err
 	}
+	// Check any requested availability zone before provisioning
+	// anything, so that a bad zone fails early with a clear message.
+	if serverId, ok := adoptedInstancePlacement(args.Placement); ok {
+		if _, err := e.adoptableServer(serverId); err != nil {
+			return "", "", nil, errors.Annotate(err, "cannot bootstrap in requested placement")
+		}
+	} else if args.Placement != "" {
+		if _, err := e.placementAvailabilityZone(args.Placement); err != nil {
+			return "", "", nil, errors.Annotate(err, "cannot bootstrap in requested placement")
+		}
+	}
+	// Check that some flavor can satisfy the bootstrap constraints
+	// before starting to provision. An adopted instance already has
+	// its flavor.
+	if _, ok := adoptedInstancePlacement(args.Placement); !ok {
+		if err := checkFlavorsSatisfy(e, args.Constraints); err != nil {
+			return "", "", nil, errors.Trace(err)
+		}
+	}
+	// Check that any configured security groups exist, as juju
+	// will not create them.
+	if _, err := e.existingSecurityGroups(); err != nil {
+		return "", "", nil, errors.Trace(err)
+	}
+	args.NoProxyHosts = append(args.NoProxyHosts, e.noProxyHosts()...)
 	return common.Bootstrap(ctx, e, args)
 }
 
-func (e *environ) StateServerInstances() ([]instance.Id, error) {
-	// Find all instances tagged with tags.JujuStateServer.
-	instances, err := e.AllInstances()
-	if err != nil {
-		return nil, errors.Trace(err)
+// noProxyHosts returns the hosts that a bootstrap instance should
+// contact directly rather than through a proxy: the identity
+// service and any configured image or agent metadata sources.
+func (e *environ) noProxyHosts() []string {
+	ecfg := e.ecfg()
+	urls := []string{ecfg.authURL()}
+	if imageURL, ok := ecfg.ImageMetadataURL(); ok {
+		urls = append(urls, imageURL)
+	}
+	if agentURL, ok := ecfg.AgentMetadataURL(); ok {
+		urls = append(urls, agentURL)
 	}
-	ids := make([]instance.Id, 0, 1)
-	for _, instance := range instances {
-		detail := instance.(*openstackInstance).getServerDetail()
-		if detail.Metadata[tags.JujuStateServer] == "true" {
-			ids = append(ids, instance.Id())
+	var hosts []string
+	for _, rawURL := range urls {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Host == "" {
+			logger.Debugf("not adding %q to no-proxy: cannot determine host", rawURL)
+			continue
+		}
+		host := u.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+func (e *environ) StateServerInstances() ([]instance.Id, error) {
+	// Find all instances tagged with tags.JujuStateServer. Shortly
+	// after bootstrap the metadata may not be visible yet, so poll
+	// to cope with eventual consistency while the environment has
+	// instances but none is tagged. An environment with no instances
+	// has not been bootstrapped, which must be reported at once.
+	var ids []instance.Id
+	for a := shortAttempt.Start(); a.Next(); {
+		instances, err := e.AllInstances()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, instance := range instances {
+			detail := instance.(*openstackInstance).getServerDetail()
+			if detail.Metadata[e.metadataKey(tags.JujuStateServer)] == "true" {
+				ids = append(ids, instance.Id())
+			}
+		}
+		if len(ids) > 0 || len(instances) == 0 {
+			break
 		}
 	}
 	if len(ids) == 0 {
@@ -895,35 +1252,213 @@ func (e *environ) resolveNetwork(networkName string) (string, error) {
 	return "", fmt.Errorf("Multiple networks with label %q: %v", networkName, networkIds)
 }
 
-// allocatePublicIP tries to find an available floating IP address, or
-// allocates a new one, returning it, or an error
-func (e *environ) allocatePublicIP() (*nova.FloatingIP, error) {
-	fips, err := e.nova().ListFloatingIPs()
+// novaListNetworks lists the networks available to the tenant.
+var novaListNetworks = (*nova.Client).ListNetworks
+
+// checkNetworkReachable checks, according to the
+// network-reachability-check setting, whether a bootstrap instance
+// started without a floating IP could be reached from the client. It
+// cannot if every network it may be started on is private, and
+// bootstrap would then hang waiting to connect to it. Networks whose
+// CIDR is not known are assumed to be reachable. Only the error setting
+// fails when the networks cannot be listed.
+func (e *environ) checkNetworkReachable() error {
+	ecfg := e.ecfg()
+	check := ecfg.networkReachabilityCheck()
+	if check == "none" || ecfg.useFloatingIP() {
+		return nil
+	}
+	networks, err := novaListNetworks(e.nova())
 	if err != nil {
-		return nil, err
+		if check == "error" {
+			return errors.Annotate(err, "cannot list networks")
+		}
+		logger.Warningf("cannot list networks to check that the bootstrap instance will be reachable: %v", err)
+		return nil
 	}
-	var newfip *nova.FloatingIP
-	for _, fip := range fips {
-		newfip = &fip
-		if fip.InstanceId != nil && *fip.InstanceId != "" {
-			// unavailable, skip
-			newfip = nil
+	usingNetwork := ecfg.network()
+	var private []string
+	for _, nw := range networks {
+		if usingNetwork != "" && nw.Id != usingNetwork && nw.Label != usingNetwork {
 			continue
-		} else {
-			logger.Debugf("found unassigned public ip: %v", newfip.IP)
-			// unassigned, we can use it
-			return newfip, nil
 		}
+		if nw.Cidr == nil {
+			return nil
+		}
+		ip, _, err := net.ParseCIDR(*nw.Cidr)
+		if err != nil || network.NewAddress(ip.String()).Scope == network.ScopePublic {
+			return nil
+		}
+		private = append(private, fmt.Sprintf("%q (%s)", nw.Label, *nw.Cidr))
+	}
+	if len(private) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf(
+		"use-floating-ip is false and the only networks available are private: %s; "+
+			"the bootstrap instance may not be reachable",
+		strings.Join(private, ", "),
+	)
+	if check == "error" {
+		return errors.Errorf("%s (set network-reachability-check to warn or none to bootstrap anyway)", msg)
+	}
+	logger.Warningf("%s", msg)
+	return nil
+}
+
+// fixedIPFilter is the Nova server list filter matching servers by
+// fixed IP address. Goose does not define it.
+const fixedIPFilter = "ip"
+
+// checkFixedIP checks that the given address may be requested as the
+// fixed IP of a new instance on the network with the given id: it must
+// be within the network's CIDR, if known, and not already used by
+// another server.
+func (e *environ) checkFixedIP(networkId, fixedIP string) error {
+	ip := net.ParseIP(fixedIP)
+	networks, err := novaListNetworks(e.nova())
+	if err != nil {
+		return errors.Annotate(err, "cannot list networks")
+	}
+	for _, network := range networks {
+		if network.Id != networkId || network.Cidr == nil {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(*network.Cidr)
+		if err != nil {
+			return errors.Annotatef(err, "cannot parse CIDR of network %q", network.Label)
+		}
+		if !ipNet.Contains(ip) {
+			return fmt.Errorf("fixed IP %s is not within network %q (%s)", fixedIP, network.Label, *network.Cidr)
+		}
+	}
+	// Nova matches the ip filter as a regular expression against the
+	// servers' fixed IPs, so only servers that may hold the address
+	// are listed. The match is checked exactly below.
+	filter := nova.NewFilter()
+	filter.Set(fixedIPFilter, "^"+regexp.QuoteMeta(fixedIP)+"$")
+	servers, err := novaListServersDetail(e.nova(), filter)
+	if err != nil {
+		return errors.Annotate(err, "cannot list servers")
 	}
-	if newfip == nil {
-		// allocate a new IP and use it
-		newfip, err = e.nova().AllocateFloatingIP()
+	for _, server := range servers {
+		for _, addresses := range server.Addresses {
+			for _, address := range addresses {
+				if address.Address == fixedIP {
+					return fmt.Errorf("fixed IP %s is already in use by instance %q", fixedIP, server.Id)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// novaListFloatingIPs lists the floating IPs available to the tenant.
+var novaListFloatingIPs = (*nova.Client).ListFloatingIPs
+
+// floatingIPMetadataKey is the Juju tag under which the id of a
+// floating IP allocated for an instance is stored in the instance's
+// Nova metadata. Nova floating IPs cannot carry metadata themselves, so
+// this is how ReconcileFloatingIPs finds the addresses Juju allocated.
+const floatingIPMetadataKey = tags.JujuTagPrefix + "floating-ip"
+
+// allocatePublicIP tries to find an available floating IP address, or
+// allocates a new one, returning it and whether it was newly
+// allocated, or an error. The returned address is reserved until
+// releasePublicIP is called, so that it will not be chosen for another
+// instance in the meantime.
+//
+// At most floating-ip-concurrency allocations are in progress at once.
+// The default of 1 keeps the number of concurrent requests to the
+// cloud to a minimum; raising it starts many machines faster, at the
+// risk of hitting the cloud's API rate limits.
+func (e *environ) allocatePublicIP() (_ *nova.FloatingIP, allocated bool, _ error) {
+	sem := e.floatingIPSemaphore()
+	sem <- struct{}{}
+	defer func() { <-sem }()
+
+	if e.ecfg().reuseFloatingIPs() {
+		fips, err := novaListFloatingIPs(e.nova())
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
-		logger.Debugf("allocated new public IP: %v", newfip.IP)
+		for _, fip := range fips {
+			if fip.InstanceId != nil && *fip.InstanceId != "" {
+				// unavailable, skip
+				continue
+			}
+			if !e.reservePublicIP(fip.Id) {
+				// chosen for another instance, skip
+				continue
+			}
+			logger.Debugf("found unassigned public ip: %v", fip.IP)
+			// unassigned, we can use it
+			newfip := fip
+			return &newfip, false, nil
+		}
+	}
+	// allocate a new IP and use it
+	newfip, err := e.nova().AllocateFloatingIP()
+	if err != nil {
+		return nil, false, err
+	}
+	logger.Debugf("allocated new public IP: %v", newfip.IP)
+	e.reservePublicIP(newfip.Id)
+	return newfip, true, nil
+}
+
+// reservePublicIP records that the floating IP with the given id has
+// been chosen for an instance. It returns false if the address was
+// already reserved.
+func (e *environ) reservePublicIP(id string) bool {
+	e.reservedFloatingIPsMutex.Lock()
+	defer e.reservedFloatingIPsMutex.Unlock()
+	if e.reservedFloatingIPs[id] {
+		return false
+	}
+	if e.reservedFloatingIPs == nil {
+		e.reservedFloatingIPs = make(map[string]bool)
 	}
-	return newfip, nil
+	e.reservedFloatingIPs[id] = true
+	return true
+}
+
+// isReservedPublicIP reports whether the floating IP with the given id
+// has been chosen for an instance that is still being started.
+func (e *environ) isReservedPublicIP(id string) bool {
+	e.reservedFloatingIPsMutex.Lock()
+	defer e.reservedFloatingIPsMutex.Unlock()
+	return e.reservedFloatingIPs[id]
+}
+
+// releasePublicIP removes the reservation made by allocatePublicIP.
+func (e *environ) releasePublicIP(fip *nova.FloatingIP) {
+	e.reservedFloatingIPsMutex.Lock()
+	defer e.reservedFloatingIPsMutex.Unlock()
+	delete(e.reservedFloatingIPs, fip.Id)
+}
+
+// deletePublicIP releases a floating IP that allocatePublicIP newly
+// allocated for an instance that could not be given it.
+func (e *environ) deletePublicIP(fip *nova.FloatingIP) {
+	if err := e.nova().DeleteFloatingIP(fip.Id); err != nil {
+		logger.Warningf("cannot release floating IP %s: %v", fip.IP, err)
+		return
+	}
+	logger.Infof("released floating IP %s", fip.IP)
+}
+
+// floatingIPSemaphore returns the semaphore limiting concurrent
+// floating IP allocations, replacing it if floating-ip-concurrency
+// has changed.
+func (e *environ) floatingIPSemaphore() chan struct{} {
+	n := e.ecfg().floatingIPConcurrency()
+	e.floatingIPSemMutex.Lock()
+	defer e.floatingIPSemMutex.Unlock()
+	if cap(e.floatingIPSem) != n {
+		e.floatingIPSem = make(chan struct{}, n)
+	}
+	return e.floatingIPSem
 }
 
 // assignPublicIP tries to assign the given floating IP address to the
@@ -947,6 +1482,64 @@ func (e *environ) assignPublicIP(fip *nova.FloatingIP, serverId string) (err err
 	return err
 }
 
+// ReconcileFloatingIPs is specified in the environs.FloatingIPReconciler
+// interface. It tidies up floating IPs that Juju allocated for
+// instances but that were left unassociated, for example because this
+// process stopped before an address could be assigned. Such addresses
+// are found from the metadata of the environment's instances, so they
+// are found after a restart too. If use-floating-ip is set and the
+// instance is alive without another floating IP, the address is
+// assigned to it; otherwise the address is released. Floating IPs not
+// recorded on any of the environment's instances are left alone, as
+// they may belong to other users of the tenant.
+func (e *environ) ReconcileFloatingIPs() error {
+	novaClient := e.nova()
+	fips, err := novaListFloatingIPs(novaClient)
+	if err != nil {
+		return errors.Annotate(err, "cannot list floating IPs")
+	}
+	servers, err := e.listMachineServers()
+	if err != nil {
+		return errors.Annotate(err, "cannot list servers")
+	}
+	unassociated := make(map[string]nova.FloatingIP)
+	withFloatingIP := make(map[string]bool)
+	for _, fip := range fips {
+		if fip.InstanceId != nil && *fip.InstanceId != "" {
+			withFloatingIP[*fip.InstanceId] = true
+			continue
+		}
+		unassociated[fip.Id] = fip
+	}
+
+	useFloatingIP := e.ecfg().useFloatingIP()
+	key := e.metadataKey(floatingIPMetadataKey)
+	for _, server := range servers {
+		fip, ok := unassociated[server.Metadata[key]]
+		if !ok {
+			continue
+		}
+		if e.isReservedPublicIP(fip.Id) {
+			// About to be assigned to an instance being started.
+			continue
+		}
+		if useFloatingIP && !withFloatingIP[server.Id] && e.isAliveServer(server) {
+			if err := novaClient.AddServerFloatingIP(server.Id, fip.IP); err != nil {
+				logger.Warningf("cannot associate floating IP %s with instance %q: %v", fip.IP, server.Id, err)
+				continue
+			}
+			withFloatingIP[server.Id] = true
+			logger.Infof("associated floating IP %s with instance %q", fip.IP, server.Id)
+			continue
+		}
+		if err := novaClient.DeleteFloatingIP(fip.Id); err != nil {
+			return errors.Annotatef(err, "cannot release floating IP %s", fip.IP)
+		}
+		logger.Infof("released unassociated floating IP %s allocated for instance %q", fip.IP, server.Id)
+	}
+	return nil
+}
+
 // DistributeInstances implements the state.InstanceDistributor policy.
 func (e *environ) DistributeInstances(candidates, distributionGroup []instance.Id) ([]instance.Id, error) {
 	return common.DistributeInstances(e, candidates, distributionGroup)
@@ -959,18 +1552,72 @@ func (*environ) MaintainInstance(args environs.StartInstanceParams) error {
 	return nil
 }
 
+// maxUserDataSize is the largest user data, once base64 encoded for
+// the request, that Nova accepts.
+var maxUserDataSize = 65535
+
+// checkUserDataSize returns an error if the given user data, which
+// ComposeUserData has already gzipped, is too large for Nova. Without
+// the check, Nova rejects the request with an unhelpful error.
+func checkUserDataSize(userData []byte) error {
+	if size := base64.StdEncoding.EncodedLen(len(userData)); size > maxUserDataSize {
+		return errors.Errorf(
+			"user data is %d bytes once compressed and encoded, more than the %d bytes allowed by Nova",
+			size, maxUserDataSize,
+		)
+	}
+	return nil
+}
+
+// isStateServer reports whether the instance config is for a machine
+// that manages the environment.
+func isStateServer(icfg *instancecfg.InstanceConfig) bool {
+	for _, job := range icfg.Jobs {
+		if job == multiwatcher.JobManageEnviron {
+			return true
+		}
+	}
+	return false
+}
+
 // StartInstance is specified in the InstanceBroker interface.
 func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.StartInstanceResult, error) {
+	if serverId, ok := adoptedInstancePlacement(args.Placement); ok {
+		if !args.InstanceConfig.Bootstrap {
+			return nil, errors.NotSupportedf("placement on an existing instance other than at bootstrap")
+		}
+		return e.adoptInstance(serverId, args)
+	}
 	var availabilityZones []string
+	var fixedIP string
 	if args.Placement != "" {
-		placement, err := e.parsePlacement(args.Placement)
+		p, err := e.parsePlacement(args.Placement)
 		if err != nil {
 			return nil, err
 		}
-		if !placement.availabilityZone.State.Available {
-			return nil, fmt.Errorf("availability zone %q is unavailable", placement.availabilityZone.Name)
+		fixedIP = p.fixedIP
+		zone, err := e.placementAvailabilityZone(args.Placement)
+		if err != nil {
+			return nil, err
+		}
+		if zone != "" {
+			availabilityZones = append(availabilityZones, zone)
+		}
+	}
+
+	// A state server is placed to keep the state servers spread
+	// across the zones allowed by state-server-zones.
+	if len(availabilityZones) == 0 && isStateServer(args.InstanceConfig) {
+		zones, err := e.stateServerZones(1)
+		if errors.IsNotImplemented(err) || errors.IsNotFound(err) {
+			// Availability zones are an extension, so we may get a
+			// not implemented error; without them, or with none
+			// available, the zone is chosen as for other machines.
+		} else if err != nil {
+			return nil, errors.Annotate(err, "cannot choose a zone for the state server")
+		} else {
+			availabilityZones = zones
 		}
-		availabilityZones = append(availabilityZones, placement.availabilityZone.Name)
 	}
 
 	// If no availability zone is specified, then automatically spread across
@@ -993,8 +1640,16 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 			return nil, err
 		} else {
 			for _, zone := range zoneInstances {
+				if e.isDrainedZone(zone.ZoneName) {
+					continue
+				}
 				availabilityZones = append(availabilityZones, zone.ZoneName)
 			}
+			if len(zoneInstances) > 0 && len(availabilityZones) == 0 {
+				// Leaving the zone unspecified would let Nova pick
+				// a drained zone.
+				return nil, errors.New("all availability zones are drained")
+			}
 		}
 		if len(availabilityZones) == 0 {
 			// No explicitly selectable zones available, so use an unspecified zone.
@@ -1005,6 +1660,11 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 	if args.InstanceConfig.HasNetworks() {
 		return nil, fmt.Errorf("starting instances with networks is not supported yet.")
 	}
+	cons, err := e.withDefaultConstraints(args.Constraints)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	args.Constraints = cons
 
 	series := args.Tools.OneSeries()
 	arches := args.Tools.Arches()
@@ -1032,6 +1692,21 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 		return nil, fmt.Errorf("cannot make user data: %v", err)
 	}
 	logger.Debugf("openstack user data; %d bytes", len(userData))
+	if err := checkUserDataSize(userData); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	metadata := prefixedMetadata(e.ecfg().metadataKeyPrefix(), args.InstanceConfig.Tags)
+	if template := e.ecfg().instanceFQDNTemplate(); template != "" {
+		fqdn, err := renderInstanceFQDN(template, e.Config().Name(), args.InstanceConfig.MachineId)
+		if err != nil {
+			return nil, err
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[e.metadataKey(fqdnMetadataKey)] = fqdn
+	}
 
 	var networks = []nova.ServerNetworks{}
 	usingNetwork := e.ecfg().network()
@@ -1041,19 +1716,53 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 			return nil, err
 		}
 		logger.Debugf("using network id %q", networkId)
-		networks = append(networks, nova.ServerNetworks{NetworkId: networkId})
+		if fixedIP != "" {
+			if err := e.checkFixedIP(networkId, fixedIP); err != nil {
+				return nil, err
+			}
+		}
+		networks = append(networks, nova.ServerNetworks{NetworkId: networkId, FixedIp: fixedIP})
+	} else if fixedIP != "" {
+		return nil, fmt.Errorf("cannot use fixed IP %s: the network setting must name the network to use", fixedIP)
+	}
+	machineName, err := e.machineServerName(args.InstanceConfig.MachineId)
+	if err != nil {
+		return nil, err
 	}
+	if machineName, err = e.uniqueServerName(machineName); err != nil {
+		return nil, err
+	}
+
 	withPublicIP := e.ecfg().useFloatingIP()
 	var publicIP *nova.FloatingIP
+	var publicIPAssigned bool
 	if withPublicIP {
 		logger.Debugf("allocating public IP address for openstack node")
-		if fip, err := e.allocatePublicIP(); err != nil {
+		fip, allocated, err := e.allocatePublicIP()
+		if err != nil {
 			return nil, fmt.Errorf("cannot allocate a public IP as needed: %v", err)
-		} else {
-			publicIP = fip
-			logger.Infof("allocated public IP %s", publicIP.IP)
+		}
+		publicIP = fip
+		logger.Infof("allocated public IP %s", publicIP.IP)
+		defer e.releasePublicIP(publicIP)
+		if allocated {
+			// Record the address on the instance, so that it can
+			// be found by ReconcileFloatingIPs if we stop before
+			// it is assigned, and release it if it cannot be.
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[e.metadataKey(floatingIPMetadataKey)] = publicIP.Id
+			defer func() {
+				if !publicIPAssigned {
+					e.deletePublicIP(publicIP)
+				}
+			}()
 		}
 	}
+	if err := e.checkServerMetadata(metadata); err != nil {
+		return nil, err
+	}
 
 	cfg := e.Config()
 	groups, err := e.setUpGroups(args.InstanceConfig.MachineId, cfg.APIPort())
@@ -1065,11 +1774,6 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 		groupNames[i] = nova.SecurityGroupName{g.Name}
 	}
 
-	machineName := resourceName(
-		names.NewMachineTag(args.InstanceConfig.MachineId),
-		e.Config().Name(),
-	)
-
 	var server *nova.Entity
 	for _, availZone := range availabilityZones {
 		var opts = nova.RunServerOpts{
@@ -1080,7 +1784,7 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 			SecurityGroupNames: groupNames,
 			Networks:           networks,
 			AvailabilityZone:   availZone,
-			Metadata:           args.InstanceConfig.Tags,
+			Metadata:           metadata,
 		}
 		for a := shortAttempt.Start(); a.Next(); {
 			server, err = e.nova().RunServer(opts)
@@ -1088,7 +1792,7 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 				break
 			}
 		}
-		if isNoValidHostsError(err) {
+		if isNoValidHostsError(err) && e.ecfg().retryZonesOnNoValidHost() {
 			logger.Infof("no valid hosts available in zone %q, trying another availability zone", availZone)
 		} else {
 			break
@@ -1117,6 +1821,7 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 			return nil, fmt.Errorf("cannot assign public address %s to instance %q: %v", publicIP.IP, inst.Id(), err)
 		}
 		inst.floatingIP = publicIP
+		publicIPAssigned = true
 		logger.Infof("assigned public IP %s to %q", publicIP.IP, inst.Id())
 	}
 	return &environs.StartInstanceResult{
@@ -1125,6 +1830,194 @@ func (e *environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 	}, nil
 }
 
+// instancePlacementPrefix introduces a bootstrap placement directive
+// naming an existing server to use as the bootstrap instance.
+const instancePlacementPrefix = "instance="
+
+// adoptedInstancePlacement returns the server id named by an
+// instance placement directive, and whether the placement was one.
+func adoptedInstancePlacement(placement string) (string, bool) {
+	if !strings.HasPrefix(placement, instancePlacementPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(placement, instancePlacementPrefix), true
+}
+
+// adoptableServer returns the details of the existing server with the
+// given id, checking that it is active and has an address through
+// which the agent can be installed.
+func (e *environ) adoptableServer(serverId string) (*nova.ServerDetail, error) {
+	detail, err := e.nova().GetServer(serverId)
+	if gooseerrors.IsNotFound(err) {
+		return nil, errors.NotFoundf("instance %q", serverId)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot get instance %q", serverId)
+	}
+	if detail.Status != nova.StatusActive {
+		return nil, errors.Errorf("instance %q is not active (status %q)", serverId, detail.Status)
+	}
+	if len(detail.Addresses) == 0 {
+		return nil, errors.Errorf("instance %q has no addresses", serverId)
+	}
+	return detail, nil
+}
+
+// adoptInstance uses an existing server as the bootstrap instance
+// rather than running a new one. The server is renamed and tagged as
+// a machine in the environment and added to its security groups; the
+// agent is then installed over SSH when bootstrap is finished, as for
+// any other bootstrap instance.
+func (e *environ) adoptInstance(serverId string, args environs.StartInstanceParams) (*environs.StartInstanceResult, error) {
+	detail, err := e.adoptableServer(serverId)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The image the server was started from may not be in the image
+	// metadata, so the architecture must be unambiguous.
+	arches := args.Tools.Arches()
+	if args.Constraints.Arch != nil {
+		arches = []string{*args.Constraints.Arch}
+	}
+	if len(arches) != 1 {
+		return nil, errors.Errorf("cannot determine architecture of instance %q: specify an arch constraint", serverId)
+	}
+	instanceArch := arches[0]
+	tools, err := args.Tools.Match(tools.Filter{Arch: instanceArch})
+	if err != nil {
+		return nil, fmt.Errorf("chosen architecture %v not present in %v", instanceArch, args.Tools.Arches())
+	}
+	args.InstanceConfig.Tools = tools[0]
+
+	groups, err := e.setUpGroups(args.InstanceConfig.MachineId, e.Config().APIPort())
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up groups: %v", err)
+	}
+	existingGroups, err := e.nova().GetServerSecurityGroups(serverId)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot get security groups of instance %q", serverId)
+	}
+	haveGroup := make(map[string]bool)
+	for _, g := range existingGroups {
+		haveGroup[g.Name] = true
+	}
+	for _, g := range groups {
+		if haveGroup[g.Name] {
+			continue
+		}
+		if err := e.nova().AddServerSecurityGroup(serverId, g.Name); err != nil {
+			return nil, errors.Annotatef(err, "cannot add instance %q to security group %q", serverId, g.Name)
+		}
+	}
+
+	machineName, err := e.machineServerName(args.InstanceConfig.MachineId)
+	if err != nil {
+		return nil, err
+	}
+	// Record the server's original name, so that it is given back
+	// rather than deleted when the instance is stopped.
+	instanceTags := map[string]string{adoptedNameTag: detail.Name}
+	for k, v := range args.InstanceConfig.Tags {
+		instanceTags[k] = v
+	}
+	if err := e.TagInstance(instance.Id(serverId), instanceTags); err != nil {
+		return nil, errors.Annotatef(err, "cannot tag instance %q", serverId)
+	}
+	if err := novaRenameServer(e.client, serverId, machineName); err != nil {
+		return nil, errors.Annotatef(err, "cannot rename instance %q", serverId)
+	}
+	detail.Name = machineName
+	if detail.Metadata == nil {
+		detail.Metadata = make(map[string]string)
+	}
+	for k, v := range instanceTags {
+		detail.Metadata[e.metadataKey(k)] = v
+	}
+	if e.ecfg().useFloatingIP() {
+		logger.Warningf("not assigning a floating IP to existing instance %q", serverId)
+	}
+	inst := &openstackInstance{
+		e:            e,
+		serverDetail: detail,
+		arch:         &instanceArch,
+	}
+	logger.Infof("adopted existing instance %q", inst.Id())
+	return &environs.StartInstanceResult{
+		Instance: inst,
+		Hardware: inst.hardwareCharacteristics(),
+	}, nil
+}
+
+// novaRenameServer renames the specified server. Goose does not
+// expose the operation, so the request is sent directly using the
+// authenticated client.
+var novaRenameServer = func(c client.Client, serverId, name string) error {
+	url := fmt.Sprintf("servers/%s", serverId)
+	requestData := goosehttp.RequestData{
+		ReqValue:       map[string]interface{}{"server": map[string]string{"name": name}},
+		ExpectedStatus: []int{http.StatusOK},
+	}
+	return c.SendRequest(client.PUT, "compute", url, &requestData)
+}
+
+// novaDeleteServerMetadata deletes the item with the given key from
+// the specified server's metadata. Goose does not expose the
+// operation, so the request is sent directly using the authenticated
+// client.
+var novaDeleteServerMetadata = func(c client.Client, serverId, key string) error {
+	url := fmt.Sprintf("servers/%s/metadata/%s", serverId, key)
+	requestData := goosehttp.RequestData{
+		ExpectedStatus: []int{http.StatusNoContent},
+	}
+	return c.SendRequest(client.DELETE, "compute", url, &requestData)
+}
+
+// adoptedNameTag is the Juju tag under which the name that an instance
+// adopted at bootstrap had beforehand is stored in its metadata.
+const adoptedNameTag = tags.JujuTagPrefix + "adopted-name"
+
+// releaseAdoptedServer gives a server adopted as the bootstrap instance
+// back to its owner rather than deleting it: it is removed from the
+// environment's security groups, given back its original name, and
+// stripped of the metadata Juju set. Anything bootstrap installed on
+// it is left in place.
+func (e *environ) releaseAdoptedServer(server *nova.ServerDetail) error {
+	novaClient := e.nova()
+	groups, err := novaClient.GetServerSecurityGroups(server.Id)
+	if err != nil {
+		return errors.Annotatef(err, "cannot get security groups of instance %q", server.Id)
+	}
+	for _, group := range groups {
+		if !e.isJujuGroupName(group.Name) {
+			continue
+		}
+		if err := novaClient.RemoveServerSecurityGroup(server.Id, group.Name); err != nil {
+			return errors.Annotatef(err, "cannot remove instance %q from security group %q", server.Id, group.Name)
+		}
+	}
+	adoptedKey := e.metadataKey(adoptedNameTag)
+	name := server.Metadata[adoptedKey]
+	if err := novaRenameServer(e.client, server.Id, name); err != nil {
+		return errors.Annotatef(err, "cannot rename instance %q", server.Id)
+	}
+	// The adopted name goes last, so that a failure leaves the
+	// server recognisably adopted.
+	var keys []string
+	prefix := e.ecfg().metadataKeyPrefix()
+	for k := range server.Metadata {
+		if strings.HasPrefix(k, prefix) && k != adoptedKey {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range append(keys, adoptedKey) {
+		if err := novaDeleteServerMetadata(e.client, server.Id, k); err != nil {
+			return errors.Annotatef(err, "cannot delete metadata %q of instance %q", k, server.Id)
+		}
+	}
+	logger.Infof("released adopted instance %q as %q instead of terminating it", server.Id, name)
+	return nil
+}
+
 func isNoValidHostsError(err error) bool {
 	gooseErr, ok := err.(gooseerrors.Error)
 	return ok && strings.Contains(gooseErr.Cause().Error(), "No valid host was found")
@@ -1162,6 +2055,30 @@ func (e *environ) StopInstances(ids ...instance.Id) error {
 	return nil
 }
 
+// novaServerAction performs the named action on the specified server.
+// Goose does not expose server actions such as os-stop, so the
+// request is sent directly using the authenticated client.
+var novaServerAction = func(c client.Client, serverId, action string) error {
+	url := fmt.Sprintf("servers/%s/action", serverId)
+	requestData := goosehttp.RequestData{
+		ReqValue:       map[string]interface{}{action: nil},
+		ExpectedStatus: []int{http.StatusAccepted},
+	}
+	return c.SendRequest(client.POST, "compute", url, &requestData)
+}
+
+func (e *environ) serverAction(id instance.Id, action string) error {
+	logger.Debugf("performing %q on instance %q", action, id)
+	err := novaServerAction(e.client, string(id), action)
+	if gooseerrors.IsNotFound(err) {
+		return errors.NotFoundf("instance %q", id)
+	}
+	if err != nil {
+		return errors.Annotatef(err, "cannot perform %q on instance %q", action, id)
+	}
+	return nil
+}
+
 func (e *environ) isAliveServer(server nova.ServerDetail) bool {
 	switch server.Status {
 	// HPCloud uses "BUILD(spawning)" as an intermediate BUILD state
@@ -1172,6 +2089,20 @@ func (e *environ) isAliveServer(server nova.ServerDetail) bool {
 	return false
 }
 
+// isListedServer reports whether the server is returned as an
+// instance. Servers that are not alive are listed only if
+// show-unhealthy-servers is set, and deleted servers never are.
+func (e *environ) isListedServer(server nova.ServerDetail) bool {
+	if e.isAliveServer(server) {
+		return true
+	}
+	switch server.Status {
+	case "DELETED", "SOFT_DELETED":
+		return false
+	}
+	return e.ecfg().showUnhealthyServers()
+}
+
 func (e *environ) listServers(ids []instance.Id) ([]nova.ServerDetail, error) {
 	wantedServers := make([]nova.ServerDetail, 0, len(ids))
 	if len(ids) == 1 {
@@ -1181,14 +2112,14 @@ func (e *environ) listServers(ids []instance.Id) ([]nova.ServerDetail, error) {
 		if err != nil {
 			return nil, err
 		}
-		// Only return server details if it is currently alive
-		if maybeServer != nil && e.isAliveServer(*maybeServer) {
+		// Only return server details if it is listed
+		if maybeServer != nil && e.isListedServer(*maybeServer) {
 			wantedServers = append(wantedServers, *maybeServer)
 		}
 		return wantedServers, nil
 	}
 	// List all servers that may be in the environment
-	servers, err := e.nova().ListServersDetail(e.machinesFilter())
+	servers, err := e.listMachineServers()
 	if err != nil {
 		return nil, err
 	}
@@ -1197,9 +2128,9 @@ func (e *environ) listServers(ids []instance.Id) ([]nova.ServerDetail, error) {
 	for _, id := range ids {
 		idSet[string(id)] = struct{}{}
 	}
-	// Return only servers with the wanted ids that are currently alive
+	// Return only servers with the wanted ids that are listed
 	for _, server := range servers {
-		if _, ok := idSet[server.Id]; ok && e.isAliveServer(server) {
+		if _, ok := idSet[server.Id]; ok && e.isListedServer(server) {
 			wantedServers = append(wantedServers, server)
 		}
 	}
@@ -1232,8 +2163,12 @@ func (e *environ) Instances(ids []instance.Id) ([]instance.Instance, error) {
 	// Make a series of requests to cope with eventual consistency.
 	// Each request will attempt to add more instances to the requested
 	// set.
+	strategy, err := e.ecfg().instancesPollStrategy()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	var foundServers []nova.ServerDetail
-	for a := shortAttempt.Start(); a.Next(); {
+	for a := strategy.Start(); a.Next(); {
 		var err error
 		foundServers, err = e.listServers(ids)
 		if err != nil {
@@ -1269,7 +2204,6 @@ func (e *environ) Instances(ids []instance.Id) ([]instance.Instance, error) {
 	}
 
 	insts := make([]instance.Instance, len(ids))
-	var err error
 	for i, id := range ids {
 		if inst := instsById[string(id)]; inst != nil {
 			insts[i] = inst
@@ -1281,13 +2215,13 @@ func (e *environ) Instances(ids []instance.Id) ([]instance.Instance, error) {
 }
 
 func (e *environ) AllInstances() (insts []instance.Instance, err error) {
-	servers, err := e.nova().ListServersDetail(e.machinesFilter())
+	servers, err := e.listMachineServers()
 	if err != nil {
 		return nil, err
 	}
 	instsById := make(map[string]instance.Instance)
 	for _, server := range servers {
-		if e.isAliveServer(server) {
+		if e.isListedServer(server) {
 			var s = server
 			// TODO(wallyworld): lookup the flavor details to fill in the instance type data
 			instsById[s.Id] = &openstackInstance{e: e, serverDetail: &s}
@@ -1307,6 +2241,17 @@ func (e *environ) AllInstances() (insts []instance.Instance, err error) {
 }
 
 func (e *environ) Destroy() error {
+	if e.ecfg().destroyMode() == "stop" {
+		stopped, err := e.softDestroy()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if stopped {
+			return environs.ErrEnvironStopped
+		}
+		// Every instance was stopped by an earlier destroy,
+		// so this one finishes the job.
+	}
 	err := common.Destroy(e)
 	if err != nil {
 		return errors.Trace(err)
@@ -1316,13 +2261,8 @@ func (e *environ) Destroy() error {
 	if err != nil {
 		return errors.Annotate(err, "cannot list security groups")
 	}
-	re, err := regexp.Compile(fmt.Sprintf("^%s(-\\d+)?$", e.jujuGroupName()))
-	if err != nil {
-		return errors.Trace(err)
-	}
-	globalGroupName := e.globalGroupName()
 	for _, group := range securityGroups {
-		if re.MatchString(group.Name) || group.Name == globalGroupName {
+		if e.isJujuGroupName(group.Name) {
 			err = novaClient.DeleteSecurityGroup(group.Id)
 			if err != nil {
 				logger.Warningf("cannot delete security group %q. Used by another environment?", group.Name)
@@ -1332,6 +2272,93 @@ func (e *environ) Destroy() error {
 	return nil
 }
 
+// stoppedMetadataKey is the Juju tag with which softDestroy marks the
+// instances it has shut off.
+const stoppedMetadataKey = tags.JujuTagPrefix + "stopped-by-destroy"
+
+var (
+	novaListVolumeAttachments = (*nova.Client).ListVolumeAttachments
+	novaDetachVolume          = (*nova.Client).DetachVolume
+)
+
+// softDestroy shuts off all the environment's instances, detaches their
+// volumes and releases their floating IPs, but leaves the instances, the
+// volumes and the environment's security groups in place, for Destroy
+// with destroy-mode stop. It reports false, having done nothing, if
+// every instance was already stopped by an earlier call, so that
+// destroying the environment again removes everything.
+func (e *environ) softDestroy() (bool, error) {
+	servers, err := e.listMachineServers()
+	if err != nil {
+		return false, errors.Annotate(err, "cannot list servers")
+	}
+	novaClient := e.nova()
+	key := e.metadataKey(stoppedMetadataKey)
+	var toStop []nova.ServerDetail
+	for _, server := range servers {
+		if e.isAliveServer(server) && server.Metadata[key] == "" {
+			toStop = append(toStop, server)
+		}
+	}
+	if len(toStop) == 0 {
+		return false, nil
+	}
+
+	serverIds := make(map[string]bool)
+	for _, server := range toStop {
+		serverIds[server.Id] = true
+		if server.Status != nova.StatusShutoff {
+			if err := e.serverAction(instance.Id(server.Id), "os-stop"); err != nil {
+				return false, errors.Trace(err)
+			}
+		}
+		attachments, err := novaListVolumeAttachments(novaClient, server.Id)
+		if err != nil {
+			return false, errors.Annotatef(err, "cannot list volume attachments of instance %q", server.Id)
+		}
+		for _, attachment := range attachments {
+			if err := novaDetachVolume(novaClient, server.Id, attachment.Id); err != nil {
+				return false, errors.Annotatef(err, "cannot detach volume %s from instance %q", attachment.VolumeId, server.Id)
+			}
+			logger.Infof("detached volume %s from instance %q", attachment.VolumeId, server.Id)
+		}
+	}
+
+	fips, err := novaClient.ListFloatingIPs()
+	if err != nil {
+		return false, errors.Annotate(err, "cannot list floating IPs")
+	}
+	for _, fip := range fips {
+		if fip.InstanceId == nil || !serverIds[*fip.InstanceId] {
+			continue
+		}
+		if err := novaClient.RemoveServerFloatingIP(*fip.InstanceId, fip.IP); err != nil {
+			return false, errors.Annotatef(err, "cannot disassociate floating IP %s", fip.IP)
+		}
+		if err := novaClient.DeleteFloatingIP(fip.Id); err != nil {
+			return false, errors.Annotatef(err, "cannot release floating IP %s", fip.IP)
+		}
+		logger.Infof("released floating IP %s from instance %q", fip.IP, *fip.InstanceId)
+	}
+
+	// Mark the instances only once everything else is done, so that a
+	// destroy that failed part way through is retried in full.
+	for id := range serverIds {
+		metadata := map[string]string{key: "true"}
+		if err := novaClient.SetServerMetadata(id, metadata); err != nil {
+			return false, errors.Annotatef(err, "cannot mark instance %q as stopped", id)
+		}
+	}
+	return true, nil
+}
+
+// isJujuGroupName reports whether the named security group is one
+// that Juju creates for the environment.
+func (e *environ) isJujuGroupName(name string) bool {
+	re := regexp.MustCompile(fmt.Sprintf("^%s(-\\d+)?$", regexp.QuoteMeta(e.jujuGroupName())))
+	return re.MatchString(name) || name == e.globalGroupName()
+}
+
 func (e *environ) globalGroupName() string {
 	return fmt.Sprintf("%s-global", e.jujuGroupName())
 }
@@ -1348,6 +2375,153 @@ func resourceName(tag names.Tag, envName string) string {
 	return fmt.Sprintf("juju-%s-%s", envName, tag)
 }
 
+// maxServerNameLength is the maximum length of a Nova server name.
+const maxServerNameLength = 255
+
+var (
+	instanceNamePlaceholder  = regexp.MustCompile(`{[^}]*}`)
+	invalidInstanceNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// renderInstanceName returns the server name for the given machine
+// built from the instance-name-template.
+func renderInstanceName(template, envName, machineId string) (string, error) {
+	var err error
+	name := instanceNamePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case "{env}":
+			return envName
+		case "{machine}":
+			return machineId
+		}
+		if err == nil {
+			err = fmt.Errorf("unknown placeholder %s in instance-name-template", placeholder)
+		}
+		return placeholder
+	})
+	if err != nil {
+		return "", err
+	}
+	name = invalidInstanceNameChars.ReplaceAllString(name, "-")
+	if len(name) > maxServerNameLength {
+		return "", fmt.Errorf("instance name %q is longer than %d characters", name, maxServerNameLength)
+	}
+	return name, nil
+}
+
+// machineServerName returns the server name for the given machine.
+func (e *environ) machineServerName(machineId string) (string, error) {
+	if template := e.ecfg().instanceNameTemplate(); template != "" {
+		return renderInstanceName(template, e.Config().Name(), machineId)
+	}
+	return resourceName(names.NewMachineTag(machineId), e.Config().Name()), nil
+}
+
+// uniqueServerName checks whether a server already has the given name
+// and, depending on instance-name-collision, either returns an error
+// or returns the name with the lowest numeric suffix not yet in use.
+// With the default, allow, no check is made and the name is returned
+// unchanged.
+func (e *environ) uniqueServerName(name string) (string, error) {
+	collision := e.ecfg().instanceNameCollision()
+	if collision == "allow" {
+		return name, nil
+	}
+	filter := nova.NewFilter()
+	filter.Set(nova.FilterServer, fmt.Sprintf("^%s(-\\d+)?$", regexp.QuoteMeta(name)))
+	servers, err := novaListServersDetail(e.nova(), filter)
+	if err != nil {
+		return "", errors.Annotate(err, "cannot list servers")
+	}
+	existing := set.NewStrings()
+	for _, server := range servers {
+		existing.Add(server.Name)
+	}
+	if !existing.Contains(name) {
+		return name, nil
+	}
+	if collision != "suffix" {
+		return "", errors.Errorf(
+			"a server named %q already exists, perhaps left over from an incomplete teardown (remove it, or set instance-name-collision to suffix)",
+			name,
+		)
+	}
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", name, i)
+		if existing.Contains(candidate) {
+			continue
+		}
+		if len(candidate) > maxServerNameLength {
+			return "", errors.Errorf("instance name %q is longer than %d characters", candidate, maxServerNameLength)
+		}
+		logger.Warningf("a server named %q already exists; naming the new instance %q", name, candidate)
+		return candidate, nil
+	}
+}
+
+// novaListServersDetail lists the details of the servers matching
+// the given filter.
+var novaListServersDetail = (*nova.Client).ListServersDetail
+
+// listMachineServers returns the details of all servers that may be
+// machines in the environment.
+func (e *environ) listMachineServers() ([]nova.ServerDetail, error) {
+	if e.ecfg().instanceNameTemplate() == "" {
+		if n := e.ecfg().listServersConcurrency(); n > 1 {
+			return e.listMachineServersInChunks(n)
+		}
+		return novaListServersDetail(e.nova(), e.machinesFilter())
+	}
+	// Servers named from a template cannot be reliably matched by
+	// name, so identify them by the environment tag instead.
+	servers, err := novaListServersDetail(e.nova(), nova.NewFilter())
+	if err != nil {
+		return nil, err
+	}
+	uuid, _ := e.Config().UUID()
+	var machineServers []nova.ServerDetail
+	for _, server := range servers {
+		if server.Metadata[e.metadataKey(tags.JujuEnv)] == uuid {
+			machineServers = append(machineServers, server)
+		}
+	}
+	return machineServers, nil
+}
+
+// listMachineServersInChunks lists the environment's machines with a
+// separate request for each last digit of the server name, with at
+// most n requests in progress at once, so that no one response holds
+// every server.
+func (e *environ) listMachineServersInChunks(n int) ([]nova.ServerDetail, error) {
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, n)
+		chunks  = make([][]nova.ServerDetail, 10)
+		errs    = make([]error, 10)
+		envName = regexp.QuoteMeta(e.Config().Name())
+	)
+	for digit := range chunks {
+		wg.Add(1)
+		go func(digit int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			filter := nova.NewFilter()
+			filter.Set(nova.FilterServer, fmt.Sprintf("juju-%s-machine-.*%d$", envName, digit))
+			chunks[digit], errs[digit] = novaListServersDetail(e.nova(), filter)
+		}(digit)
+	}
+	wg.Wait()
+	var servers []nova.ServerDetail
+	for digit, chunk := range chunks {
+		if errs[digit] != nil {
+			return nil, errs[digit]
+		}
+		servers = append(servers, chunk...)
+	}
+	return servers, nil
+}
+
 // machinesFilter returns a nova.Filter matching all machines in the environment.
 func (e *environ) machinesFilter() *nova.Filter {
 	filter := nova.NewFilter()
@@ -1521,21 +2695,29 @@ func (e *environ) setUpGlobalGroup(groupName string, apiPort int) (nova.Security
 // people that happen to share an openstack account and name their environment
 // "openstack" don't end up destroying each other's machines.
 func (e *environ) setUpGroups(machineId string, apiPort int) ([]nova.SecurityGroup, error) {
-	jujuGroup, err := e.setUpGlobalGroup(e.jujuGroupName(), apiPort)
-	if err != nil {
-		return nil, err
-	}
-	var machineGroup nova.SecurityGroup
-	switch e.Config().FirewallMode() {
-	case config.FwInstance:
-		machineGroup, err = e.ensureGroup(e.machineGroupName(machineId), nil)
-	case config.FwGlobal:
-		machineGroup, err = e.ensureGroup(e.globalGroupName(), nil)
+	var groups []nova.SecurityGroup
+	if e.ecfg().manageSecurityGroups() {
+		jujuGroup, err := e.setUpGlobalGroup(e.jujuGroupName(), apiPort)
+		if err != nil {
+			return nil, err
+		}
+		var machineGroup nova.SecurityGroup
+		switch e.Config().FirewallMode() {
+		case config.FwInstance:
+			machineGroup, err = e.ensureGroup(e.machineGroupName(machineId), nil)
+		case config.FwGlobal:
+			machineGroup, err = e.ensureGroup(e.globalGroupName(), nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, jujuGroup, machineGroup)
 	}
+	existingGroups, err := e.existingSecurityGroups()
 	if err != nil {
 		return nil, err
 	}
-	groups := []nova.SecurityGroup{jujuGroup, machineGroup}
+	groups = append(groups, existingGroups...)
 	if e.ecfg().useDefaultSecurityGroup() {
 		defaultGroup, err := e.nova().SecurityGroupByName("default")
 		if err != nil {
@@ -1549,6 +2731,21 @@ func (e *environ) setUpGroups(machineId string, apiPort int) ([]nova.SecurityGro
 // zeroGroup holds the zero security group.
 var zeroGroup nova.SecurityGroup
 
+// existingSecurityGroups returns the pre-existing security groups
+// named in the security-groups configuration attribute. These groups
+// are never created or deleted by juju.
+func (e *environ) existingSecurityGroups() ([]nova.SecurityGroup, error) {
+	var groups []nova.SecurityGroup
+	for _, name := range e.ecfg().securityGroups() {
+		group, err := e.nova().SecurityGroupByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("loading security group %q: %v", name, err)
+		}
+		groups = append(groups, *group)
+	}
+	return groups, nil
+}
+
 // ensureGroup returns the security group with name and perms.
 // If a group with name does not exist, one will be created.
 // If it exists, its permissions are set to perms.
@@ -1625,10 +2822,26 @@ func (e *environ) terminateInstances(ids []instance.Id) error {
 	if len(ids) == 0 {
 		return nil
 	}
+	// A server adopted at bootstrap belonged to the user beforehand,
+	// so it is given back rather than deleted. The servers are looked
+	// up together to find those; if that fails, they are all deleted.
+	servers := make(map[string]nova.ServerDetail)
+	found, err := e.listServers(ids)
+	if err != nil && !gooseerrors.IsNotFound(err) {
+		logger.Warningf("cannot look up instances to terminate: %v", err)
+	}
+	for _, server := range found {
+		servers[server.Id] = server
+	}
 	var firstErr error
 	novaClient := e.nova()
+	adoptedKey := e.metadataKey(adoptedNameTag)
 	for _, id := range ids {
-		err := novaClient.DeleteServer(string(id))
+		if server, ok := servers[string(id)]; ok && server.Metadata[adoptedKey] != "" {
+			err = e.releaseAdoptedServer(&server)
+		} else {
+			err = novaClient.DeleteServer(string(id))
+		}
 		if gooseerrors.IsNotFound(err) {
 			err = nil
 		}
@@ -1642,18 +2855,33 @@ func (e *environ) terminateInstances(ids []instance.Id) error {
 
 // MetadataLookupParams returns parameters which are used to query simplestreams metadata.
 func (e *environ) MetadataLookupParams(region string) (*simplestreams.MetadataLookupParams, error) {
+	return e.MetadataLookupParamsForSeries(region, "", nil)
+}
+
+// MetadataLookupParamsForSeries returns parameters for looking up
+// simplestreams metadata for the given series and architectures,
+// independent of the environment's preferred series. An empty series
+// means the preferred series and no architectures means all supported
+// architectures.
+func (e *environ) MetadataLookupParamsForSeries(region, series string, arches []string) (*simplestreams.MetadataLookupParams, error) {
 	if region == "" {
 		region = e.ecfg().region()
 	}
+	if series == "" {
+		series = config.PreferredSeries(e.ecfg())
+	}
+	if len(arches) == 0 {
+		arches = arch.AllSupportedArches
+	}
 	cloudSpec, err := e.cloudSpec(region)
 	if err != nil {
 		return nil, err
 	}
 	return &simplestreams.MetadataLookupParams{
-		Series:        config.PreferredSeries(e.ecfg()),
+		Series:        series,
 		Region:        cloudSpec.Region,
 		Endpoint:      cloudSpec.Endpoint,
-		Architectures: arch.AllSupportedArches,
+		Architectures: arches,
 	}, nil
 }
 
@@ -1671,8 +2899,15 @@ func (e *environ) cloudSpec(region string) (simplestreams.CloudSpec, error) {
 
 // TagInstance implements environs.InstanceTagger.
 func (e *environ) TagInstance(id instance.Id, tags map[string]string) error {
-	if err := e.nova().SetServerMetadata(string(id), tags); err != nil {
+	metadata := prefixedMetadata(e.ecfg().metadataKeyPrefix(), tags)
+	if err := e.nova().SetServerMetadata(string(id), metadata); err != nil {
 		return errors.Annotate(err, "setting server metadata")
 	}
 	return nil
 }
+
+// metadataKey returns the Nova metadata key under which the given
+// Juju tag is stored for the environment.
+func (e *environ) metadataKey(key string) string {
+	return prefixedMetadataKey(e.ecfg().metadataKeyPrefix(), key)
+}
@@ -350,8 +350,10 @@ func (*localTests) TestRuleMatchesPortRange(c *gc.C) {
 }
 
 func (t *localTests) TestPrepareSetsControlBucket(c *gc.C) {
+	// With a block source set, the cloud is not contacted.
 	attrs := testing.FakeConfig().Merge(testing.Attrs{
-		"type": "openstack",
+		"type":                         "openstack",
+		"storage-default-block-source": "loop",
 	})
 	cfg, err := config.New(config.NoDefaults, attrs)
 	c.Assert(err, jc.ErrorIsNil)
@@ -362,3 +364,31 @@ func (t *localTests) TestPrepareSetsControlBucket(c *gc.C) {
 	bucket := cfg.UnknownAttrs()["control-bucket"]
 	c.Assert(bucket, gc.Matches, "[a-f0-9]{32}")
 }
+
+var instanceNameTests = []struct {
+	template string
+	expected string
+	err      string
+}{{
+	template: "{env}-{machine}",
+	expected: "foo-1",
+}, {
+	template: "team/{env} {machine}",
+	expected: "team-foo-1",
+}, {
+	template: "{env}-{bogus}-{machine}",
+	err:      `unknown placeholder {bogus} in instance-name-template`,
+}}
+
+func (*localTests) TestRenderInstanceName(c *gc.C) {
+	for i, t := range instanceNameTests {
+		c.Logf("test %d: %s", i, t.template)
+		name, err := openstack.RenderInstanceName(t.template, "foo", "1")
+		if t.err != "" {
+			c.Check(err, gc.ErrorMatches, t.err)
+			continue
+		}
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(name, gc.Equals, t.expected)
+	}
+}
@@ -6,9 +6,12 @@ package provisioner
 import (
 	"reflect"
 
+	apiprovisioner "github.com/juju/juju/api/provisioner"
 	"github.com/juju/juju/api/watcher"
+	"github.com/juju/juju/environs"
 	"github.com/juju/juju/environs/config"
 	"github.com/juju/juju/network"
+	"github.com/juju/juju/worker"
 )
 
 func SetObserver(p Provisioner, observer chan<- *config.Config) {
@@ -24,6 +27,10 @@ func SetObserver(p Provisioner, observer chan<- *config.Config) {
 	configObserver.Unlock()
 }
 
+func NewInstanceTagger(st *apiprovisioner.State, tagger environs.InstanceTagger) worker.Worker {
+	return newInstanceTagger(st, tagger)
+}
+
 func GetRetryWatcher(p Provisioner) (watcher.NotifyWatcher, error) {
 	return p.getRetryWatcher()
 }
@@ -0,0 +1,218 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package provisioner
+
+import (
+	"github.com/juju/errors"
+	"github.com/juju/names"
+	"launchpad.net/tomb"
+
+	apiprovisioner "github.com/juju/juju/api/provisioner"
+	apiwatcher "github.com/juju/juju/api/watcher"
+	"github.com/juju/juju/apiserver/params"
+	"github.com/juju/juju/environs"
+	"github.com/juju/juju/environs/tags"
+	"github.com/juju/juju/state/watcher"
+)
+
+// deployedTags are the instance tags describing what is deployed to
+// a machine. They are cleared when the last unit leaves the machine.
+var deployedTags = []string{
+	tags.JujuUnitsDeployed,
+	tags.JujuServicesDeployed,
+}
+
+// instanceTagger keeps the tags of the environment's instances up to
+// date as units are assigned to and removed from their machines. The
+// tags are set when an instance is started, but the units deployed to
+// it change over its lifetime.
+type instanceTagger struct {
+	tomb         tomb.Tomb
+	st           *apiprovisioner.State
+	tagger       environs.InstanceTagger
+	machineds    map[names.MachineTag]*taggedMachine
+	unitsChanged chan names.MachineTag
+}
+
+// newInstanceTagger returns a new instanceTagger that tags instances
+// with the given tagger.
+func newInstanceTagger(st *apiprovisioner.State, tagger environs.InstanceTagger) *instanceTagger {
+	t := &instanceTagger{
+		st:           st,
+		tagger:       tagger,
+		machineds:    make(map[names.MachineTag]*taggedMachine),
+		unitsChanged: make(chan names.MachineTag),
+	}
+	go func() {
+		defer t.tomb.Done()
+		t.tomb.Kill(t.loop())
+	}()
+	return t
+}
+
+func (t *instanceTagger) loop() error {
+	machinesWatcher, err := t.st.WatchEnvironMachines()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer watcher.Stop(machinesWatcher, &t.tomb)
+	defer t.stopMachines()
+	for {
+		select {
+		case <-t.tomb.Dying():
+			return tomb.ErrDying
+		case ids, ok := <-machinesWatcher.Changes():
+			if !ok {
+				return watcher.EnsureErr(machinesWatcher)
+			}
+			for _, id := range ids {
+				if err := t.machineLifeChanged(names.NewMachineTag(id)); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		case tag := <-t.unitsChanged:
+			if err := t.updateTags(tag); err != nil {
+				return errors.Annotatef(err, "cannot update tags of %q", tag)
+			}
+		}
+	}
+}
+
+// machineLifeChanged starts watching the units of a new machine, and
+// stops watching those of a dead or removed one.
+func (t *instanceTagger) machineLifeChanged(tag names.MachineTag) error {
+	m, err := t.st.Machine(tag)
+	found := !params.IsCodeNotFound(err)
+	if found && err != nil {
+		return err
+	}
+	dead := !found || m.Life() == params.Dead
+	machined, known := t.machineds[tag]
+	if known && dead {
+		delete(t.machineds, tag)
+		return machined.Stop()
+	}
+	if known || dead {
+		return nil
+	}
+	unitw, err := m.WatchUnits()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	machined = &taggedMachine{t: t, tag: tag}
+	t.machineds[tag] = machined
+	go machined.watchLoop(unitw)
+	return nil
+}
+
+// updateTags sets the instance tags of the machine with the given tag
+// to those it would be started with now.
+func (t *instanceTagger) updateTags(tag names.MachineTag) error {
+	m, err := t.st.Machine(tag)
+	if params.IsCodeNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	instId, err := m.InstanceId()
+	if params.IsCodeNotProvisioned(err) || params.IsCodeNotFound(err) {
+		// The tags are set when the instance is started.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	info, err := m.ProvisioningInfo()
+	if params.IsCodeNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	machineTags := make(map[string]string)
+	for _, key := range deployedTags {
+		machineTags[key] = ""
+	}
+	for key, value := range info.Tags {
+		machineTags[key] = value
+	}
+	if err := t.tagger.TagInstance(instId, machineTags); err != nil {
+		// The tags are only informational, so a failure to
+		// set them is not worth stopping the provisioner.
+		logger.Warningf("cannot tag instance %q of %q: %v", instId, tag, err)
+	}
+	return nil
+}
+
+// stopMachines stops watching the units of all machines.
+func (t *instanceTagger) stopMachines() {
+	for _, machined := range t.machineds {
+		watcher.Stop(machined, &t.tomb)
+	}
+}
+
+// Kill implements worker.Worker.Kill.
+func (t *instanceTagger) Kill() {
+	t.tomb.Kill(nil)
+}
+
+// Wait implements worker.Worker.Wait.
+func (t *instanceTagger) Wait() error {
+	return t.tomb.Wait()
+}
+
+// Stop stops the instanceTagger and returns any error it encountered.
+func (t *instanceTagger) Stop() error {
+	t.tomb.Kill(nil)
+	return t.tomb.Wait()
+}
+
+// Dying returns a channel that is closed when the instanceTagger
+// begins to die.
+func (t *instanceTagger) Dying() <-chan struct{} {
+	return t.tomb.Dying()
+}
+
+// Err returns the reason why the instanceTagger has stopped or
+// tomb.ErrStillAlive when it is still alive.
+func (t *instanceTagger) Err() error {
+	return t.tomb.Err()
+}
+
+// taggedMachine watches the units assigned to a machine.
+type taggedMachine struct {
+	tomb tomb.Tomb
+	t    *instanceTagger
+	tag  names.MachineTag
+}
+
+// watchLoop notifies the instanceTagger when units are assigned to or
+// removed from the machine, including once at the start.
+func (tm *taggedMachine) watchLoop(unitw apiwatcher.StringsWatcher) {
+	defer tm.tomb.Done()
+	defer watcher.Stop(unitw, &tm.tomb)
+	for {
+		select {
+		case <-tm.tomb.Dying():
+			return
+		case _, ok := <-unitw.Changes():
+			if !ok {
+				_, err := tm.t.st.Machine(tm.tag)
+				if !params.IsCodeNotFound(err) {
+					tm.t.tomb.Kill(watcher.EnsureErr(unitw))
+				}
+				return
+			}
+			select {
+			case tm.t.unitsChanged <- tm.tag:
+			case <-tm.tomb.Dying():
+				return
+			}
+		}
+	}
+}
+
+// Stop stops watching the machine's units.
+func (tm *taggedMachine) Stop() error {
+	tm.tomb.Kill(nil)
+	return tm.tomb.Wait()
+}
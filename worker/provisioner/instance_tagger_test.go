@@ -0,0 +1,80 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package provisioner_test
+
+import (
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/environs/tags"
+	"github.com/juju/juju/instance"
+	coretesting "github.com/juju/juju/testing"
+	"github.com/juju/juju/worker"
+	"github.com/juju/juju/worker/provisioner"
+)
+
+type tagInstanceCall struct {
+	id   instance.Id
+	tags map[string]string
+}
+
+// fakeInstanceTagger records the calls made to TagInstance.
+type fakeInstanceTagger struct {
+	calls chan tagInstanceCall
+}
+
+func (t *fakeInstanceTagger) TagInstance(id instance.Id, tags map[string]string) error {
+	t.calls <- tagInstanceCall{id, tags}
+	return nil
+}
+
+// waitTags waits for the given instance to be given the expected
+// deployment tags, ignoring any other calls.
+func (t *fakeInstanceTagger) waitTags(c *gc.C, id instance.Id, units, services string) {
+	timeout := time.After(coretesting.LongWait)
+	for {
+		select {
+		case call := <-t.calls:
+			c.Logf("instance %q tagged with %v", call.id, call.tags)
+			if call.id == id &&
+				call.tags[tags.JujuUnitsDeployed] == units &&
+				call.tags[tags.JujuServicesDeployed] == services {
+				return
+			}
+		case <-timeout:
+			c.Fatalf("instance %q not tagged with units %q and services %q", id, units, services)
+		}
+	}
+}
+
+func (s *ProvisionerSuite) TestInstanceTaggerUpdatesDeployedTags(c *gc.C) {
+	m, err := s.addMachine()
+	c.Assert(err, jc.ErrorIsNil)
+	err = m.SetProvisioned("i-tagged", "fake_nonce", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	tagger := &fakeInstanceTagger{calls: make(chan tagInstanceCall, 100)}
+	w := provisioner.NewInstanceTagger(s.provisioner, tagger)
+	defer func() {
+		c.Assert(worker.Stop(w), jc.ErrorIsNil)
+	}()
+
+	// The instance is tagged when the tagger starts.
+	tagger.waitTags(c, "i-tagged", "", "")
+
+	// The tags follow the units assigned to the machine.
+	wordpress := s.AddTestingService(c, "wordpress", s.AddTestingCharm(c, "wordpress"))
+	unit, err := wordpress.AddUnit()
+	c.Assert(err, jc.ErrorIsNil)
+	err = unit.AssignToMachine(m)
+	c.Assert(err, jc.ErrorIsNil)
+	tagger.waitTags(c, "i-tagged", "wordpress/0", "wordpress")
+
+	// They are cleared when the last unit leaves.
+	err = unit.Destroy()
+	c.Assert(err, jc.ErrorIsNil)
+	tagger.waitTags(c, "i-tagged", "", "")
+}
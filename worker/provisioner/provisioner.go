@@ -190,6 +190,14 @@ func (p *environProvisioner) loop() error {
 	}
 	p.broker = p.environ
 
+	// Tidy up after any earlier provisioner that stopped while
+	// starting instances, before starting any more.
+	if reconciler, ok := p.environ.(environs.FloatingIPReconciler); ok {
+		if err := reconciler.ReconcileFloatingIPs(); err != nil {
+			logger.Warningf("cannot reconcile floating IPs: %v", err)
+		}
+	}
+
 	harvestMode := p.environ.Config().ProvisionerHarvestMode()
 	task, err := p.getStartTask(harvestMode)
 	if err != nil {
@@ -197,6 +205,16 @@ func (p *environProvisioner) loop() error {
 	}
 	defer watcher.Stop(task, &p.tomb)
 
+	// Keep the instance tags describing what is deployed to each
+	// machine up to date, if the environment supports tagging.
+	var instanceTagger *instanceTagger
+	var instanceTaggerDying <-chan struct{}
+	if tagger, ok := p.environ.(environs.InstanceTagger); ok {
+		instanceTagger = newInstanceTagger(p.st, tagger)
+		defer watcher.Stop(instanceTagger, &p.tomb)
+		instanceTaggerDying = instanceTagger.Dying()
+	}
+
 	for {
 		select {
 		case <-p.tomb.Dying():
@@ -205,6 +223,10 @@ func (p *environProvisioner) loop() error {
 			err := task.Err()
 			logger.Errorf("environ provisioner died: %v", err)
 			return err
+		case <-instanceTaggerDying:
+			err := instanceTagger.Err()
+			logger.Errorf("instance tagger died: %v", err)
+			return err
 		case _, ok := <-environConfigChanges:
 			if !ok {
 				return watcher.EnsureErr(environWatcher)